@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+
+	"github.com/phoops/bellatrix/internal/core/usecases"
+	"github.com/phoops/bellatrix/internal/infrastructure/plan"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var stateFileFlagName = "state-file"
+
+var applyCmd = &cobra.Command{
+	Run: func(cmd *cobra.Command, args []string) {
+		startApply(cmd, args)
+	},
+	Use:   "apply PLAN FILE",
+	Short: "Apply a plan saved with plan --out, if the context broker has not changed in the meantime",
+	Args:  cobra.ExactArgs(1),
+}
+
+func init() {
+	applyCmd.Flags().String(stateFileFlagName, "", "State file with the client options, defaults to the STATE_FILE env variable")
+}
+
+func startApply(cmd *cobra.Command, args []string) {
+	instancePrefix := getInstancePrefix(cmd)
+	logger := newLogger(getDebug(cmd))
+	ctx, cancel := newContext(cmd, logger)
+	defer cancel()
+
+	stateFilePath, err := cmd.Flags().GetString(stateFileFlagName)
+	if err != nil {
+		panic(err)
+	}
+	if stateFilePath == "" {
+		stateFilePath = os.Getenv(stateFileEnvVariable)
+	}
+
+	subscriptionsPlan, err := plan.NewFileStore(logger).ReadPlanFile(args[0])
+	if err != nil {
+		logger.Fatal("Error during the reading of the plan", zap.Error(err))
+	}
+	if subscriptionsPlan == nil {
+		logger.Fatal("Empty plan file provided, aborting")
+	}
+
+	// the state file is needed only for the context broker client options,
+	// the patches to apply are the ones saved in the plan
+	stateFromFile := loadSubscriptionsState(logger, stateFilePath, instancePrefix)
+	orionClient := newOrionClient(ctx, cmd, logger, stateFromFile.ClientOptions)
+
+	getAvailableSubscriptionsUsecase := newGetAvailableSubscriptions(cmd, orionClient, logger)
+	applySubscriptionsPlanUsecase := usecases.NewApplySubscriptionsPlan(
+		usecases.NewGetSubscriptionsFingerprint(
+			getAvailableSubscriptionsUsecase,
+			instancePrefix,
+		),
+		newApplySubscriptionsPatches(cmd, orionClient, getAvailableSubscriptionsUsecase, logger),
+		logger,
+		instancePrefix,
+	)
+
+	results, err := applySubscriptionsPlanUsecase.Execute(ctx, subscriptionsPlan)
+	if getKeepGoing(cmd) && len(results) != 0 {
+		if err := plan.NewRenderer(false).RenderReport(os.Stdout, results); err != nil {
+			logger.Error("Error during the rendering of the report", zap.Error(err))
+		}
+	}
+	if err != nil {
+		logger.Fatal("Error during plan execution", zap.Error(err))
+	}
+
+	logger.Info("Done, plan applied")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/phoops/bellatrix/internal/core/usecases"
+	"github.com/phoops/bellatrix/internal/infrastructure/state"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var (
+	clientURLFlagName        = "client-url"
+	headerFlagName           = "header"
+	includeUnmanagedFlagName = "include-unmanaged"
+)
+
+var exportCmd = &cobra.Command{
+	Run: func(cmd *cobra.Command, args []string) {
+		startExport(cmd, args)
+	},
+	Use:   "export",
+	Short: "Generate a state file from the subscriptions on a context broker",
+}
+
+func init() {
+	addScopesFlags(exportCmd)
+	exportCmd.Flags().String(clientURLFlagName, "", "Context broker url, written in the client options")
+	exportCmd.Flags().StringArray(headerFlagName, nil, "Additional header in the form key=value, written in the client options, can be repeated")
+	exportCmd.Flags().String(outFlagName, "", "State file to write, defaults to the standard output")
+	exportCmd.Flags().Bool(includeUnmanagedFlagName, false, "Export the subscriptions not managed by bellatrix too")
+}
+
+func startExport(cmd *cobra.Command, args []string) {
+	instancePrefix := getInstancePrefix(cmd)
+	logger := newLogger(getDebug(cmd))
+	ctx, cancel := newContext(cmd, logger)
+	defer cancel()
+
+	clientURL, err := cmd.Flags().GetString(clientURLFlagName)
+	if err != nil {
+		panic(err)
+	}
+	headers, err := cmd.Flags().GetStringArray(headerFlagName)
+	if err != nil {
+		panic(err)
+	}
+	stateFilePath, err := cmd.Flags().GetString(outFlagName)
+	if err != nil {
+		panic(err)
+	}
+	includeUnmanaged, err := cmd.Flags().GetBool(includeUnmanagedFlagName)
+	if err != nil {
+		panic(err)
+	}
+
+	if clientURL == "" {
+		logger.Fatal("Context broker url not provided, use --client-url")
+	}
+	scopes, err := getScopes(cmd)
+	if err != nil {
+		logger.Fatal("Error during the reading of the scopes", zap.Error(err))
+	}
+	if len(scopes) == 0 {
+		logger.Fatal("No scopes provided, use --scope or --scopes-file")
+	}
+
+	clientOptions := entities.OrionClientOptions{ClientURL: clientURL}
+	for _, header := range headers {
+		parts := strings.SplitN(header, "=", 2)
+		if len(parts) != 2 {
+			logger.Fatal("Invalid header provided, use key=value", zap.String("header", header))
+		}
+		if clientOptions.AdditionalHeaders == nil {
+			clientOptions.AdditionalHeaders = make(map[string]string)
+		}
+		clientOptions.AdditionalHeaders[parts[0]] = parts[1]
+	}
+
+	orionClient := newOrionClient(ctx, cmd, logger, clientOptions)
+	exportSubscriptionsStateUsecase := usecases.NewExportSubscriptionsState(
+		newGetAvailableSubscriptions(cmd, orionClient, logger),
+		instancePrefix,
+	)
+
+	subsState, err := exportSubscriptionsStateUsecase.Execute(ctx, scopes, clientOptions, includeUnmanaged)
+	if err != nil {
+		logger.Fatal("Error during the export of subscriptions", zap.Error(err))
+	}
+
+	if stateFilePath != "" {
+		err = state.NewWriter(logger).WriteSubscriptionFile(stateFilePath, subsState)
+		if err != nil {
+			logger.Fatal("Error during the writing of the state file", zap.Error(err))
+		}
+		logger.Info("State exported", zap.String("state_file_path", stateFilePath))
+		return
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(subsState)
+	if err != nil {
+		logger.Fatal("Error during the writing of the state", zap.Error(err))
+	}
+}
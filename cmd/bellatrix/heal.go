@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+
+	"github.com/phoops/bellatrix/internal/core/usecases"
+	"github.com/phoops/bellatrix/internal/infrastructure/plan"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var healCmd = &cobra.Command{
+	Run: func(cmd *cobra.Command, args []string) {
+		startHeal(cmd, args)
+	},
+	Use:   "heal [CONFIG FILE]",
+	Short: "Heal the failed subscriptions of your state file, without syncing the others",
+}
+
+func init() {
+	addSelectionFlags(healCmd)
+}
+
+func startHeal(cmd *cobra.Command, args []string) {
+	dryRun := getDryRun(cmd)
+	instancePrefix := getInstancePrefix(cmd)
+	logger := newLogger(getDebug(cmd))
+	ctx, cancel := newContext(cmd, logger)
+	defer cancel()
+	keepGoing := getApplyMode(cmd, logger) == usecases.ApplyModeKeepGoing
+	selection, err := getSelection(cmd)
+	if err != nil {
+		logger.Fatal("Error during the reading of the selectors", zap.Error(err))
+	}
+
+	stateFromFile := loadSubscriptionsState(logger, getStateFilePath(args), instancePrefix)
+	orionClient := newOrionClient(ctx, cmd, logger, stateFromFile.ClientOptions)
+
+	getAvailableSubscriptionsUsecase := newGetAvailableSubscriptions(cmd, orionClient, logger)
+	ensureSubscriptionsAreActiveUsecase := usecases.NewEnsureSubscriptionsAreActive(
+		getAvailableSubscriptionsUsecase,
+		logger.Sugar(),
+		newCreateSubscription(cmd, orionClient, getAvailableSubscriptionsUsecase, logger),
+		newUpdateSubscription(cmd, orionClient, logger),
+		newDeleteSubscription(cmd, orionClient, logger),
+		newGetSubscriptionsHealth(cmd, orionClient, stateFromFile.ClientOptions, logger),
+		instancePrefix,
+		keepGoing,
+		dryRun,
+		getParallelism(cmd),
+		selection,
+	)
+
+	results, err := ensureSubscriptionsAreActiveUsecase.Execute(ctx, stateFromFile.SubscriptionsState)
+
+	renderErr := plan.NewRenderer(false).RenderHealReport(os.Stdout, results, dryRun)
+	if renderErr != nil {
+		logger.Error("Error during the rendering of the report", zap.Error(renderErr))
+	}
+
+	if err != nil {
+		logger.Fatal("Error during the heal of the failed subscriptions", zap.Error(err))
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"text/tabwriter"
+
+	"github.com/phoops/bellatrix/internal/core/usecases"
+	"github.com/phoops/bellatrix/internal/infrastructure/state"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var (
+	idFlagName    = "id"
+	matchFlagName = "match"
+)
+
+var importCmd = &cobra.Command{
+	Run: func(cmd *cobra.Command, args []string) {
+		startImport(cmd, args)
+	},
+	Use:   "import [CONFIG FILE]",
+	Short: "Adopt subscriptions created by hand, adding them to your state file",
+	Long: `Without --id or --match lists the unmanaged subscriptions of the scope.
+The selected subscriptions are appended to the state file and renamed with the
+bellatrix prefix on the context broker, keeping their ids.`,
+}
+
+func init() {
+	importCmd.Flags().String(scopeFlagName, "", "Scope in the form fiware-service:service-path")
+	importCmd.Flags().StringArray(idFlagName, nil, "Id of the subscription to import, can be repeated")
+	importCmd.Flags().String(matchFlagName, "", "Import the subscriptions with a description matching the regular expression")
+}
+
+func startImport(cmd *cobra.Command, args []string) {
+	instancePrefix := getInstancePrefix(cmd)
+	logger := newLogger(getDebug(cmd))
+	ctx, cancel := newContext(cmd, logger)
+	defer cancel()
+
+	rawScope, err := cmd.Flags().GetString(scopeFlagName)
+	if err != nil {
+		panic(err)
+	}
+	ids, err := cmd.Flags().GetStringArray(idFlagName)
+	if err != nil {
+		panic(err)
+	}
+	match, err := cmd.Flags().GetString(matchFlagName)
+	if err != nil {
+		panic(err)
+	}
+
+	scope, err := parseScope(rawScope)
+	if err != nil {
+		logger.Fatal("Invalid scope provided, use --scope fiware-service:service-path", zap.Error(err))
+	}
+	var descriptionPattern *regexp.Regexp
+	if match != "" {
+		descriptionPattern, err = regexp.Compile(match)
+		if err != nil {
+			logger.Fatal("Invalid description pattern provided", zap.Error(err))
+		}
+	}
+
+	stateFilePath := getStateFilePath(args)
+	stateFromFile := loadSubscriptionsState(logger, stateFilePath, instancePrefix)
+	orionClient := newOrionClient(ctx, cmd, logger, stateFromFile.ClientOptions)
+
+	getUnmanagedSubscriptionsUsecase := usecases.NewGetUnmanagedSubscriptions(
+		newGetAvailableSubscriptions(cmd, orionClient, logger),
+		instancePrefix,
+	)
+
+	if len(ids) == 0 && descriptionPattern == nil {
+		unmanagedSubscriptions, err := getUnmanagedSubscriptionsUsecase.Execute(ctx, scope)
+		if err != nil {
+			logger.Fatal("Error during the listing of unmanaged subscriptions", zap.Error(err))
+		}
+
+		table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(table, "ID\tDESCRIPTION")
+		for _, sub := range unmanagedSubscriptions {
+			fmt.Fprintf(table, "%s\t%s\n", sub.Id, sub.Description)
+		}
+		if err := table.Flush(); err != nil {
+			logger.Fatal("Error during the listing of unmanaged subscriptions", zap.Error(err))
+		}
+		return
+	}
+
+	if getDryRun(cmd) {
+		logger.Info("Dry run mode, nothing imported")
+		return
+	}
+
+	importSubscriptionsUsecase := usecases.NewImportSubscriptions(
+		getUnmanagedSubscriptionsUsecase,
+		state.NewParser(logger),
+		state.NewWriter(logger),
+		newUpdateSubscription(cmd, orionClient, logger),
+		logger,
+		instancePrefix,
+	)
+
+	imported, err := importSubscriptionsUsecase.Execute(ctx, stateFilePath, scope, ids, descriptionPattern)
+	if err != nil {
+		logger.Fatal("Error during the import of subscriptions", zap.Error(err))
+	}
+
+	logger.Info("Done, subscriptions imported", zap.Int("imported", len(imported)))
+}
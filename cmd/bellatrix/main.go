@@ -1,11 +1,20 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/phoops/bellatrix/internal/core/entities"
 	"github.com/phoops/bellatrix/internal/core/usecases"
+	"github.com/phoops/bellatrix/internal/infrastructure/broker"
+	"github.com/phoops/bellatrix/internal/infrastructure/ratelimit"
 	"github.com/phoops/bellatrix/internal/infrastructure/state"
 	"github.com/phoops/ngsiv2/client"
 	"github.com/pkg/errors"
@@ -21,8 +30,33 @@ var (
 	dryRunEnvVariable         = "DRY_RUN"
 	instancePrefixFlagName    = "instance-prefix"
 	instancePrefixEnvVariable = "INSTANCE_PREFIX"
+	pageSizeFlagName          = "page-size"
+	maxSubscriptionsFlagName  = "max-subscriptions"
+	transactionalFlagName     = "transactional"
+	transactionalEnvVariable  = "TRANSACTIONAL"
+	keepGoingFlagName         = "keep-going"
+	keepGoingEnvVariable      = "KEEP_GOING"
+	parallelismFlagName       = "parallelism"
+	rateLimitFlagName         = "rate-limit"
+	rateLimitEnvVariable      = "RATE_LIMIT"
+	rateLimitBurstFlagName    = "rate-limit-burst"
+	rateLimitBurstEnvVariable = "RATE_LIMIT_BURST"
+	retryAttemptsFlagName     = "retry-attempts"
+	retryAttemptsEnvVariable  = "RETRY_ATTEMPTS"
+	retryBackoffFlagName      = "retry-backoff"
+	retryMaxBackoffFlagName   = "retry-max-backoff"
+	timeoutFlagName           = "timeout"
+	timeoutEnvVariable        = "TIMEOUT"
+	requestTimeoutFlagName    = "request-timeout"
+	maxDeletionsFlagName      = "max-deletions"
+	maxDeletionsPctFlagName   = "max-deletions-percent"
+	allowDestroyFlagName      = "allow-destroy"
+	allowDestroyEnvVariable   = "ALLOW_DESTROY"
 )
 
+// defaultRequestTimeout is the timeout of the ngsiv2 client
+const defaultRequestTimeout = 15 * time.Second
+
 // Version of the program, modified by ldflags
 var Version = "development"
 
@@ -35,14 +69,6 @@ var rootCmd = &cobra.Command{
 	Long:  `A fast and stateless orion subscription manger`,
 }
 
-var syncCmd = &cobra.Command{
-	Run: func(cmd *cobra.Command, args []string) {
-		startBellatrix(cmd, args)
-	},
-	Use:   "sync [CONFIG FILE]",
-	Short: "Sync your orion subscriptions with your state file",
-}
-
 var versionCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		fmt.Printf("Version %s, BuildDate %s", Version, BuildDate)
@@ -55,8 +81,30 @@ func init() {
 	rootCmd.PersistentFlags().Bool(debugFlagName, false, "Set the debug mode on cli")
 	rootCmd.PersistentFlags().Bool(dryRunFlagName, false, "Dry run mode, does not apply patches")
 	rootCmd.PersistentFlags().String(instancePrefixFlagName, "", "Optional Instance Prefix")
+	rootCmd.PersistentFlags().Bool(transactionalFlagName, false, "Roll back the applied changes when a patch fails")
+	rootCmd.PersistentFlags().Bool(keepGoingFlagName, false, "Attempt every operation, report the failures at the end")
+	rootCmd.PersistentFlags().Int(parallelismFlagName, 1, "Number of fiware-service/service path scopes reconciled concurrently")
+	rootCmd.PersistentFlags().Float64(rateLimitFlagName, 0, "Maximum number of requests per second sent to context broker, 0 means unlimited")
+	rootCmd.PersistentFlags().Int(rateLimitBurstFlagName, 1, "Number of requests that can be sent to context broker in a burst, over the rate limit")
+	rootCmd.PersistentFlags().Int(retryAttemptsFlagName, usecases.DefaultRetryAttempts, "Number of attempts of a request to context broker failed with a network error, a 5xx or a 429")
+	rootCmd.PersistentFlags().Duration(retryBackoffFlagName, usecases.DefaultRetryInitialBackoff, "Wait before the first retry, doubled at every retry, with jitter")
+	rootCmd.PersistentFlags().Duration(retryMaxBackoffFlagName, usecases.DefaultRetryMaxBackoff, "Maximum wait between two retries")
+	rootCmd.PersistentFlags().Duration(timeoutFlagName, 0, "Maximum duration of the whole run, 0 means no limit")
+	rootCmd.PersistentFlags().Duration(requestTimeoutFlagName, defaultRequestTimeout, "Maximum duration of a single request to context broker")
+	rootCmd.PersistentFlags().Int(maxDeletionsFlagName, 0, "Maximum number of subscriptions deleted by a run, 0 means no limit")
+	rootCmd.PersistentFlags().Float64(maxDeletionsPctFlagName, usecases.DefaultMaxDeletionsPercent, "Maximum percentage of the managed subscriptions deleted by a run, 0 means no limit")
+	rootCmd.PersistentFlags().Bool(allowDestroyFlagName, false, "Allow the deletions over the thresholds and the removal of all the subscriptions of a scope")
+	rootCmd.PersistentFlags().Int(pageSizeFlagName, usecases.DefaultSubscriptionsPageSize, "Number of subscriptions retrieved with a single request to context broker")
+	rootCmd.PersistentFlags().Int(maxSubscriptionsFlagName, usecases.DefaultMaxSubscriptionsPerScope, "Maximum number of subscriptions retrieved for a single fiware-service and service path")
 
 	rootCmd.AddCommand(syncCmd)
+	rootCmd.AddCommand(planCmd)
+	rootCmd.AddCommand(applyCmd)
+	rootCmd.AddCommand(orphansCmd)
+	rootCmd.AddCommand(importCmd)
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(healCmd)
 	rootCmd.AddCommand(versionCmd)
 }
 
@@ -67,7 +115,7 @@ func main() {
 	}
 }
 
-func startBellatrix(cmd *cobra.Command, args []string) {
+func getDebug(cmd *cobra.Command) bool {
 	debug, err := cmd.Flags().GetBool(debugFlagName)
 	if err != nil {
 		panic(err)
@@ -76,6 +124,10 @@ func startBellatrix(cmd *cobra.Command, args []string) {
 		// try for env variable
 		_, debug = os.LookupEnv(debugFlagEnvVariable)
 	}
+	return debug
+}
+
+func getDryRun(cmd *cobra.Command) bool {
 	dryRun, err := cmd.Flags().GetBool(dryRunFlagName)
 	if err != nil {
 		panic(err)
@@ -84,7 +136,219 @@ func startBellatrix(cmd *cobra.Command, args []string) {
 		// try for env variable
 		_, dryRun = os.LookupEnv(dryRunEnvVariable)
 	}
+	return dryRun
+}
+
+func getTransactional(cmd *cobra.Command) bool {
+	transactional, err := cmd.Flags().GetBool(transactionalFlagName)
+	if err != nil {
+		panic(err)
+	}
+	if !transactional {
+		// try for env variable
+		_, transactional = os.LookupEnv(transactionalEnvVariable)
+	}
+	return transactional
+}
+
+func getKeepGoing(cmd *cobra.Command) bool {
+	keepGoing, err := cmd.Flags().GetBool(keepGoingFlagName)
+	if err != nil {
+		panic(err)
+	}
+	if !keepGoing {
+		// try for env variable
+		_, keepGoing = os.LookupEnv(keepGoingEnvVariable)
+	}
+	return keepGoing
+}
+
+func getApplyMode(cmd *cobra.Command, logger *zap.Logger) usecases.ApplyMode {
+	transactional := getTransactional(cmd)
+	keepGoing := getKeepGoing(cmd)
+	switch {
+	case transactional && keepGoing:
+		logger.Fatal("Transactional and keep going modes cannot be used together")
+	case transactional:
+		return usecases.ApplyModeTransactional
+	case keepGoing:
+		return usecases.ApplyModeKeepGoing
+	}
+	return usecases.ApplyModeFailFast
+}
+
+func getParallelism(cmd *cobra.Command) int {
+	parallelism, err := cmd.Flags().GetInt(parallelismFlagName)
+	if err != nil {
+		panic(err)
+	}
+	return parallelism
+}
+
+func getRateLimit(cmd *cobra.Command) (float64, int) {
+	requestsPerSecond, err := cmd.Flags().GetFloat64(rateLimitFlagName)
+	if err != nil {
+		panic(err)
+	}
+	if !cmd.Flags().Changed(rateLimitFlagName) {
+		// try for env variable
+		if value, ok := os.LookupEnv(rateLimitEnvVariable); ok {
+			requestsPerSecond, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				panic(errors.Wrapf(err, "invalid %s env variable", rateLimitEnvVariable))
+			}
+		}
+	}
+
+	burst, err := cmd.Flags().GetInt(rateLimitBurstFlagName)
+	if err != nil {
+		panic(err)
+	}
+	if !cmd.Flags().Changed(rateLimitBurstFlagName) {
+		// try for env variable
+		if value, ok := os.LookupEnv(rateLimitBurstEnvVariable); ok {
+			burst, err = strconv.Atoi(value)
+			if err != nil {
+				panic(errors.Wrapf(err, "invalid %s env variable", rateLimitBurstEnvVariable))
+			}
+		}
+	}
+
+	return requestsPerSecond, burst
+}
+
+func getRetryPolicy(cmd *cobra.Command) usecases.RetryPolicy {
+	attempts, err := cmd.Flags().GetInt(retryAttemptsFlagName)
+	if err != nil {
+		panic(err)
+	}
+	if !cmd.Flags().Changed(retryAttemptsFlagName) {
+		// try for env variable
+		if value, ok := os.LookupEnv(retryAttemptsEnvVariable); ok {
+			attempts, err = strconv.Atoi(value)
+			if err != nil {
+				panic(errors.Wrapf(err, "invalid %s env variable", retryAttemptsEnvVariable))
+			}
+		}
+	}
+	backoff, err := cmd.Flags().GetDuration(retryBackoffFlagName)
+	if err != nil {
+		panic(err)
+	}
+	maxBackoff, err := cmd.Flags().GetDuration(retryMaxBackoffFlagName)
+	if err != nil {
+		panic(err)
+	}
+
+	return usecases.NewRetryPolicy(attempts, backoff, maxBackoff)
+}
+
+func getTimeout(cmd *cobra.Command) time.Duration {
+	timeout, err := cmd.Flags().GetDuration(timeoutFlagName)
+	if err != nil {
+		panic(err)
+	}
+	if !cmd.Flags().Changed(timeoutFlagName) {
+		// try for env variable
+		if value, ok := os.LookupEnv(timeoutEnvVariable); ok {
+			timeout, err = time.ParseDuration(value)
+			if err != nil {
+				panic(errors.Wrapf(err, "invalid %s env variable", timeoutEnvVariable))
+			}
+		}
+	}
+	return timeout
+}
+
+func getRequestTimeout(cmd *cobra.Command) time.Duration {
+	requestTimeout, err := cmd.Flags().GetDuration(requestTimeoutFlagName)
+	if err != nil {
+		panic(err)
+	}
+	return requestTimeout
+}
+
+// newContext returns the context of a run, it is done when SIGTERM or SIGINT
+// is received or when the run timeout expires: the operations in flight complete,
+// no other operation is started
+func newContext(cmd *cobra.Command, logger *zap.Logger) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	cancel := stop
+	if timeout := getTimeout(cmd); timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+		cancel = func() {
+			cancelTimeout()
+			stop()
+		}
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		select {
+		case <-finished:
+			return
+		default:
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			logger.Warn("Timeout expired, completing the operations in flight and stopping")
+			return
+		}
+		logger.Warn("Termination requested, completing the operations in flight and stopping")
+	}()
 
+	return ctx, func() {
+		close(finished)
+		cancel()
+	}
+}
+
+func getDestroyGuard(cmd *cobra.Command) usecases.DestroyGuard {
+	maxDeletions, err := cmd.Flags().GetInt(maxDeletionsFlagName)
+	if err != nil {
+		panic(err)
+	}
+	maxDeletionsPercent, err := cmd.Flags().GetFloat64(maxDeletionsPctFlagName)
+	if err != nil {
+		panic(err)
+	}
+	allowDestroy, err := cmd.Flags().GetBool(allowDestroyFlagName)
+	if err != nil {
+		panic(err)
+	}
+	if !allowDestroy {
+		// try for env variable
+		_, allowDestroy = os.LookupEnv(allowDestroyEnvVariable)
+	}
+
+	return usecases.DestroyGuard{
+		MaxDeletions:        maxDeletions,
+		MaxDeletionsPercent: maxDeletionsPercent,
+		AllowDestroy:        allowDestroy,
+	}
+}
+
+// fatalPatchesError stops the run when the patches could not be computed,
+// explaining how to proceed when they have been refused by the destroy guard
+// or by a protected subscription
+func fatalPatchesError(logger *zap.Logger, err error) {
+	if errors.Cause(err) == usecases.ErrDestructiveChanges {
+		logger.Fatal(
+			"Destructive changes refused, check the state file, then pass --allow-destroy or raise --max-deletions and --max-deletions-percent",
+			zap.Error(err),
+		)
+	}
+	if errors.Cause(err) == usecases.ErrProtectedSubscription {
+		logger.Fatal(
+			"Changes to protected subscriptions refused, remove prevent_destroy from the state file with a separate sync first",
+			zap.Error(err),
+		)
+	}
+	logger.Fatal("Error during the computing of state patches", zap.Error(err))
+}
+
+func getInstancePrefix(cmd *cobra.Command) string {
 	instancePrefix, err := cmd.Flags().GetString(instancePrefixFlagName)
 	if err != nil {
 		panic(err)
@@ -93,28 +357,41 @@ func startBellatrix(cmd *cobra.Command, args []string) {
 		// try for env variable
 		instancePrefix, _ = os.LookupEnv(instancePrefixEnvVariable)
 	}
+	return instancePrefix
+}
+
+func newLogger(debug bool) *zap.Logger {
 	baseConfig := zap.NewDevelopmentConfig()
-	var logger *zap.Logger
 	if debug {
-		logger, err = baseConfig.Build()
+		logger, err := baseConfig.Build()
 		if err != nil {
 			panic(errors.Wrap(err, "could not initialize zap logger development mode"))
 		}
-	} else {
-		baseConfig.Level = zap.NewAtomicLevelAt(zap.InfoLevel)
-		logger, err = baseConfig.Build()
-		if err != nil {
-			panic(errors.Wrap(err, "could not initialize zap logger production mode"))
-		}
+		return logger
+	}
+
+	baseConfig.Level = zap.NewAtomicLevelAt(zap.InfoLevel)
+	logger, err := baseConfig.Build()
+	if err != nil {
+		panic(errors.Wrap(err, "could not initialize zap logger production mode"))
 	}
+	return logger
+}
 
-	// get the state file path
-	stateFilePath := ""
+// getStateFilePath returns the state file path passed as first argument,
+// or set by the env variable
+func getStateFilePath(args []string) string {
 	if len(args) > 0 {
-		stateFilePath = args[0]
-	} else {
-		stateFilePath = os.Getenv(stateFileEnvVariable)
+		return args[0]
 	}
+	return os.Getenv(stateFileEnvVariable)
+}
+
+func loadSubscriptionsState(
+	logger *zap.Logger,
+	stateFilePath string,
+	instancePrefix string,
+) *entities.SubscriptionsRequestedState {
 	if stateFilePath == "" {
 		logger.Fatal("State file path not provided, aborting")
 	}
@@ -129,62 +406,162 @@ func startBellatrix(cmd *cobra.Command, args []string) {
 		logger.Fatal("Error during state file parsing", zap.Error(err))
 	}
 	logger.Debug("State from file", zap.Any("content", stateFromFile))
+
+	return stateFromFile
+}
+
+// rateLimitTransportOnce guards the replacement of http.DefaultTransport with the rate limiter
+var rateLimitTransportOnce sync.Once
+
+func newOrionClient(
+	ctx context.Context,
+	cmd *cobra.Command,
+	logger *zap.Logger,
+	orionClientOptions entities.OrionClientOptions,
+) *client.NgsiV2Client {
 	clientOptions := []client.ClientOptionFunc{
-		client.SetUrl(stateFromFile.ClientOptions.ClientURL),
+		client.SetUrl(orionClientOptions.ClientURL),
+		client.SetClientTimeout(getRequestTimeout(cmd)),
 	}
-	for header, value := range stateFromFile.ClientOptions.AdditionalHeaders {
+	for header, value := range orionClientOptions.AdditionalHeaders {
 		clientOptions = append(
 			clientOptions,
 			client.SetGlobalHeader(header, value),
 		)
 	}
 
+	// the ngsiv2 client does not accept an http client and always uses
+	// http.DefaultTransport: it is replaced on purpose, so that every request
+	// to the context broker goes through the rate limiter. bellatrix is a cli
+	// and talks only to the context broker, nothing else relies on the default.
+	// The rate limiter never retries, 429 responses are retried by the retry policy.
+	// It is wrapped once, a second client shares the same limiter
+	rateLimitTransportOnce.Do(func() {
+		requestsPerSecond, burst := getRateLimit(cmd)
+		http.DefaultTransport = ratelimit.NewTransport(
+			ctx,
+			http.DefaultTransport,
+			requestsPerSecond,
+			burst,
+			logger,
+		)
+	})
+
 	orionClient, err := client.NewNgsiV2Client(
 		clientOptions...,
 	)
 	if err != nil {
 		logger.Fatal("Error during orion client creation", zap.Error(err))
 	}
-	getAvailableSubscriptionsUsecase := usecases.NewGetAvailableSubscriptions(
+
+	// the client discovers the api resources of the context broker on the first request,
+	// in a way that is not safe for concurrent use, so we trigger the discovery now,
+	// before the parallel workers start
+	if getParallelism(cmd) > 1 {
+		err = usecases.NewDiscoverAPIResources(orionClient, getRetryPolicy(cmd), logger).Execute(ctx)
+		if err != nil {
+			logger.Fatal("Error during orion api resources discovery", zap.Error(err))
+		}
+	}
+
+	return orionClient
+}
+
+func newGetAvailableSubscriptions(
+	cmd *cobra.Command,
+	orionClient *client.NgsiV2Client,
+	logger *zap.Logger,
+) *usecases.GetAvailableSubscriptions {
+	pageSize, maxSubscriptions := getPagination(cmd)
+
+	return usecases.NewGetAvailableSubscriptions(
 		orionClient,
-	)
-	getSubscriptionsPatchesUsecase := usecases.NewGetSubscriptionsPatches(
-		getAvailableSubscriptionsUsecase,
+		pageSize,
+		maxSubscriptions,
+		getRetryPolicy(cmd),
 		logger,
-		instancePrefix,
 	)
-	applySubscriptionsPatchesUsecase := usecases.NewApplySubscriptionsPatches(
-		orionClient,
+}
+
+// newGetSubscriptionsHealth reads the subscriptions fields the orion client does not expose
+// with plain requests, they go through the rate limiter as well
+func newGetSubscriptionsHealth(
+	cmd *cobra.Command,
+	orionClient *client.NgsiV2Client,
+	orionClientOptions entities.OrionClientOptions,
+	logger *zap.Logger,
+) *usecases.GetSubscriptionsHealth {
+	pageSize, maxSubscriptions := getPagination(cmd)
+
+	return usecases.NewGetSubscriptionsHealth(
+		broker.NewHealthReader(
+			orionClient,
+			orionClientOptions.ClientURL,
+			orionClientOptions.AdditionalHeaders,
+			getRequestTimeout(cmd),
+			logger,
+		),
+		pageSize,
+		maxSubscriptions,
+		getRetryPolicy(cmd),
 		logger,
 	)
-	ensureSubscriptionsAreActiveUsecase := usecases.NewEnsureSubscriptionsAreActive(
-		getAvailableSubscriptionsUsecase,
-		logger.Sugar(),
-		orionClient,
-		instancePrefix,
-	)
+}
 
-	patches, err := getSubscriptionsPatchesUsecase.Execute(stateFromFile.SubscriptionsState)
+func getPagination(cmd *cobra.Command) (int, int) {
+	pageSize, err := cmd.Flags().GetInt(pageSizeFlagName)
 	if err != nil {
-		logger.Fatal("Error during the computing of state patches", zap.Error(err))
+		panic(err)
 	}
+	maxSubscriptions, err := cmd.Flags().GetInt(maxSubscriptionsFlagName)
+	if err != nil {
+		panic(err)
+	}
+	return pageSize, maxSubscriptions
+}
 
-	if !dryRun {
-		err = applySubscriptionsPatchesUsecase.Execute(patches)
-
-		if err != nil {
-			logger.Fatal("Error during patch execution", zap.Error(err))
-		}
+func newCreateSubscription(
+	cmd *cobra.Command,
+	orionClient *client.NgsiV2Client,
+	getAvailableSubscriptions *usecases.GetAvailableSubscriptions,
+	logger *zap.Logger,
+) *usecases.CreateSubscription {
+	return usecases.NewCreateSubscription(
+		orionClient,
+		getAvailableSubscriptions,
+		getRetryPolicy(cmd),
+		logger,
+	)
+}
 
-		logger.Info("Ensuring the subscriptions are in the active state")
-		err = ensureSubscriptionsAreActiveUsecase.Execute(
-			stateFromFile.SubscriptionsState,
-		)
+func newUpdateSubscription(
+	cmd *cobra.Command,
+	orionClient *client.NgsiV2Client,
+	logger *zap.Logger,
+) *usecases.UpdateSubscription {
+	return usecases.NewUpdateSubscription(orionClient, getRetryPolicy(cmd), logger)
+}
 
-		if err != nil {
-			logger.Fatal("Error during the ensuring of subscriptions active state", zap.Error(err))
-		}
-	}
+func newDeleteSubscription(
+	cmd *cobra.Command,
+	orionClient *client.NgsiV2Client,
+	logger *zap.Logger,
+) *usecases.DeleteSubscription {
+	return usecases.NewDeleteSubscription(orionClient, getRetryPolicy(cmd), logger)
+}
 
-	logger.Info("Done, hope you had a nice sync :D")
+func newApplySubscriptionsPatches(
+	cmd *cobra.Command,
+	orionClient *client.NgsiV2Client,
+	getAvailableSubscriptions *usecases.GetAvailableSubscriptions,
+	logger *zap.Logger,
+) *usecases.ApplySubscriptionsPatches {
+	return usecases.NewApplySubscriptionsPatches(
+		newCreateSubscription(cmd, orionClient, getAvailableSubscriptions, logger),
+		newUpdateSubscription(cmd, orionClient, logger),
+		newDeleteSubscription(cmd, orionClient, logger),
+		logger,
+		getApplyMode(cmd, logger),
+		getParallelism(cmd),
+	)
 }
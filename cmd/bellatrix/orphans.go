@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+
+	"github.com/phoops/bellatrix/internal/core/usecases"
+	"github.com/phoops/bellatrix/internal/infrastructure/plan"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var deleteFlagName = "delete"
+
+var orphansCmd = &cobra.Command{
+	Run: func(cmd *cobra.Command, args []string) {
+		startOrphans(cmd, args)
+	},
+	Use:   "orphans [CONFIG FILE]",
+	Short: "Find the managed subscriptions not claimed by your state file in a set of scopes",
+}
+
+func init() {
+	addScopesFlags(orphansCmd)
+	orphansCmd.Flags().StringP(outputFlagName, "o", outputText, "Output format, text or json")
+	orphansCmd.Flags().Bool(noColorFlagName, false, "Disable the colored text output")
+	orphansCmd.Flags().Bool(deleteFlagName, false, "Delete the orphan subscriptions found")
+	orphansCmd.Flags().Bool(autoApproveFlagName, false, "Delete the orphan subscriptions without asking for confirmation")
+}
+
+func startOrphans(cmd *cobra.Command, args []string) {
+	instancePrefix := getInstancePrefix(cmd)
+	logger := newLogger(getDebug(cmd))
+	ctx, cancel := newContext(cmd, logger)
+	defer cancel()
+
+	output, err := cmd.Flags().GetString(outputFlagName)
+	if err != nil {
+		panic(err)
+	}
+	if output != outputText && output != outputJSON {
+		logger.Fatal("Invalid output format, use text or json", zap.String("output", output))
+	}
+	noColor, err := cmd.Flags().GetBool(noColorFlagName)
+	if err != nil {
+		panic(err)
+	}
+	deleteOrphans, err := cmd.Flags().GetBool(deleteFlagName)
+	if err != nil {
+		panic(err)
+	}
+
+	candidateScopes, err := getScopes(cmd)
+	if err != nil {
+		logger.Fatal("Error during the reading of the scopes", zap.Error(err))
+	}
+	if len(candidateScopes) == 0 {
+		logger.Fatal("No candidate scopes provided, use --scope or --scopes-file")
+	}
+
+	stateFromFile := loadSubscriptionsState(logger, getStateFilePath(args), instancePrefix)
+	orionClient := newOrionClient(ctx, cmd, logger, stateFromFile.ClientOptions)
+
+	getAvailableSubscriptionsUsecase := newGetAvailableSubscriptions(cmd, orionClient, logger)
+	// listing the orphans deletes nothing, the destroy guard applies to --delete only
+	destroyGuard := usecases.DestroyGuard{AllowDestroy: true}
+	if deleteOrphans {
+		destroyGuard = getDestroyGuard(cmd)
+	}
+	getOrphanSubscriptionsUsecase := usecases.NewGetOrphanSubscriptions(
+		getAvailableSubscriptionsUsecase,
+		logger,
+		instancePrefix,
+		destroyGuard,
+	)
+
+	orphanPatches, searchErr := getOrphanSubscriptionsUsecase.Execute(
+		ctx,
+		candidateScopes,
+		stateFromFile.SubscriptionsState,
+	)
+	if searchErr != nil && errors.Cause(searchErr) != usecases.ErrDestructiveChanges {
+		logger.Fatal("Error during the search of orphan subscriptions", zap.Error(searchErr))
+	}
+
+	renderer := plan.NewRenderer(!noColor && isTerminal(os.Stdout))
+	if output == outputJSON {
+		err = renderer.RenderJSON(os.Stdout, orphanPatches)
+	} else {
+		err = renderer.RenderText(os.Stdout, orphanPatches)
+	}
+	if err != nil {
+		logger.Fatal("Error during the rendering of the orphan subscriptions", zap.Error(err))
+	}
+
+	// the orphans refused by the destroy guard are listed before stopping
+	if searchErr != nil {
+		fatalPatchesError(logger, searchErr)
+	}
+	if !deleteOrphans || getDryRun(cmd) || !hasChanges(orphanPatches) {
+		return
+	}
+	if !approveChanges(ctx, cmd, logger, nil) {
+		logger.Info("Deletions not approved, nothing deleted")
+		return
+	}
+
+	results, err := newApplySubscriptionsPatches(cmd, orionClient, getAvailableSubscriptionsUsecase, logger).Execute(ctx, orphanPatches)
+	if getKeepGoing(cmd) && len(results) != 0 {
+		if err := renderer.RenderReport(os.Stdout, results); err != nil {
+			logger.Error("Error during the rendering of the report", zap.Error(err))
+		}
+	}
+	if err != nil {
+		logger.Fatal("Error during the deletion of orphan subscriptions", zap.Error(err))
+	}
+
+	logger.Info("Done, orphan subscriptions deleted")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+
+	"github.com/phoops/bellatrix/internal/core/usecases"
+	"github.com/phoops/bellatrix/internal/infrastructure/plan"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"golang.org/x/term"
+)
+
+var (
+	outputFlagName  = "output"
+	noColorFlagName = "no-color"
+	outFlagName     = "out"
+	outputText      = "text"
+	outputJSON      = "json"
+)
+
+var planCmd = &cobra.Command{
+	Run: func(cmd *cobra.Command, args []string) {
+		startPlan(cmd, args)
+	},
+	Use:   "plan [CONFIG FILE]",
+	Short: "Show the changes sync would apply to your orion subscriptions",
+}
+
+func init() {
+	planCmd.Flags().StringP(outputFlagName, "o", outputText, "Output format, text or json")
+	planCmd.Flags().Bool(noColorFlagName, false, "Disable the colored text output")
+	planCmd.Flags().String(outFlagName, "", "Save the plan to a file, it can be executed later with the apply command")
+	addSelectionFlags(planCmd)
+}
+
+func startPlan(cmd *cobra.Command, args []string) {
+	instancePrefix := getInstancePrefix(cmd)
+	logger := newLogger(getDebug(cmd))
+	ctx, cancel := newContext(cmd, logger)
+	defer cancel()
+
+	output, err := cmd.Flags().GetString(outputFlagName)
+	if err != nil {
+		panic(err)
+	}
+	if output != outputText && output != outputJSON {
+		logger.Fatal("Invalid output format, use text or json", zap.String("output", output))
+	}
+	noColor, err := cmd.Flags().GetBool(noColorFlagName)
+	if err != nil {
+		panic(err)
+	}
+	planFilePath, err := cmd.Flags().GetString(outFlagName)
+	if err != nil {
+		panic(err)
+	}
+	selection, err := getSelection(cmd)
+	if err != nil {
+		logger.Fatal("Error during the reading of the selectors", zap.Error(err))
+	}
+
+	stateFromFile := loadSubscriptionsState(logger, getStateFilePath(args), instancePrefix)
+	orionClient := newOrionClient(ctx, cmd, logger, stateFromFile.ClientOptions)
+
+	getAvailableSubscriptionsUsecase := newGetAvailableSubscriptions(cmd, orionClient, logger)
+	createSubscriptionsPlanUsecase := usecases.NewCreateSubscriptionsPlan(
+		usecases.NewGetSubscriptionsPatches(
+			getAvailableSubscriptionsUsecase,
+			logger,
+			instancePrefix,
+			getParallelism(cmd),
+			getDestroyGuard(cmd),
+			selection,
+		),
+		usecases.NewGetSubscriptionsFingerprint(
+			getAvailableSubscriptionsUsecase,
+			instancePrefix,
+		),
+		instancePrefix,
+	)
+
+	subscriptionsPlan, err := createSubscriptionsPlanUsecase.Execute(ctx, stateFromFile.SubscriptionsState)
+	if err != nil {
+		fatalPatchesError(logger, err)
+	}
+	patches := subscriptionsPlan.Patches
+
+	if planFilePath != "" {
+		err = plan.NewFileStore(logger).WritePlanFile(planFilePath, subscriptionsPlan)
+		if err != nil {
+			logger.Fatal("Error during the saving of the plan", zap.Error(err))
+		}
+		logger.Info("Plan saved", zap.String("plan_file_path", planFilePath))
+	}
+
+	renderer := plan.NewRenderer(!noColor && isTerminal(os.Stdout))
+	if output == outputJSON {
+		err = renderer.RenderJSON(os.Stdout, patches)
+	} else {
+		err = renderer.RenderText(os.Stdout, patches)
+	}
+	if err != nil {
+		logger.Fatal("Error during the rendering of the plan", zap.Error(err))
+	}
+}
+
+// isTerminal reports if the file is attached to a terminal
+func isTerminal(file *os.File) bool {
+	return term.IsTerminal(int(file.Fd()))
+}
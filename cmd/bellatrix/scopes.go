@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	scopeFlagName      = "scope"
+	scopesFileFlagName = "scopes-file"
+)
+
+// addScopesFlags adds the flags used to select the fiware-service/service path
+// couples a command works on
+func addScopesFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArray(scopeFlagName, nil, "Scope in the form fiware-service:service-path, can be repeated")
+	cmd.Flags().String(scopesFileFlagName, "", "File containing a scope in the form fiware-service:service-path on each line")
+}
+
+// getScopes returns the scopes passed with the scope flags and read from the scopes file
+func getScopes(cmd *cobra.Command) ([]entities.SubscriptionsScope, error) {
+	rawScopes, err := cmd.Flags().GetStringArray(scopeFlagName)
+	if err != nil {
+		panic(err)
+	}
+	scopesFilePath, err := cmd.Flags().GetString(scopesFileFlagName)
+	if err != nil {
+		panic(err)
+	}
+
+	if scopesFilePath != "" {
+		scopesFile, err := os.Open(scopesFilePath)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not open the scopes file")
+		}
+		defer scopesFile.Close()
+
+		scanner := bufio.NewScanner(scopesFile)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			rawScopes = append(rawScopes, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, errors.Wrap(err, "could not read the scopes file")
+		}
+	}
+
+	var scopes []entities.SubscriptionsScope
+	seen := make(map[entities.SubscriptionsScope]bool)
+	for _, rawScope := range rawScopes {
+		scope, err := parseScope(rawScope)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes, nil
+}
+
+// parseScope parses a scope in the form fiware-service:service-path,
+// both the parts are optional, like in the state file
+func parseScope(rawScope string) (entities.SubscriptionsScope, error) {
+	parts := strings.SplitN(rawScope, ":", 2)
+	if len(parts) != 2 {
+		return entities.SubscriptionsScope{}, errors.Errorf(
+			"invalid scope %q, expected fiware-service:service-path",
+			rawScope,
+		)
+	}
+	return entities.SubscriptionsScope{
+		FiwareService: strings.TrimSpace(parts[0]),
+		ServicePath:   strings.TrimSpace(parts[1]),
+	}, nil
+}
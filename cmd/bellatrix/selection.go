@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/phoops/bellatrix/internal/core/usecases"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	targetFlagName  = "target"
+	excludeFlagName = "exclude"
+)
+
+// addSelectionFlags adds the flags used to restrict a command
+// to a part of the subscriptions of the state
+func addSelectionFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArray(
+		targetFlagName,
+		nil,
+		"Work only on the subscriptions matching the selector, e.g. fiware_service=tenant,description=alerts-*, can be repeated",
+	)
+	cmd.Flags().StringArray(
+		excludeFlagName,
+		nil,
+		"Skip the subscriptions matching the selector, e.g. label.team=ops, can be repeated",
+	)
+}
+
+// getSelection returns the selection built from the target and exclude flags
+func getSelection(cmd *cobra.Command) (usecases.Selection, error) {
+	rawTargets, err := cmd.Flags().GetStringArray(targetFlagName)
+	if err != nil {
+		panic(err)
+	}
+	rawExcludes, err := cmd.Flags().GetStringArray(excludeFlagName)
+	if err != nil {
+		panic(err)
+	}
+
+	var selection usecases.Selection
+	for _, rawTarget := range rawTargets {
+		target, err := parseSelector(rawTarget)
+		if err != nil {
+			return usecases.Selection{}, err
+		}
+		selection.Targets = append(selection.Targets, target)
+	}
+	for _, rawExclude := range rawExcludes {
+		exclude, err := parseSelector(rawExclude)
+		if err != nil {
+			return usecases.Selection{}, err
+		}
+		selection.Excludes = append(selection.Excludes, exclude)
+	}
+
+	return selection, nil
+}
+
+// parseSelector parses a selector in the form field=pattern[,field=pattern...],
+// all the conditions must match
+func parseSelector(rawSelector string) (usecases.Selector, error) {
+	var selector usecases.Selector
+	for _, rawCondition := range strings.Split(rawSelector, ",") {
+		parts := strings.SplitN(rawCondition, "=", 2)
+		if len(parts) != 2 {
+			return usecases.Selector{}, errors.Errorf(
+				"invalid selector %q, expected field=pattern[,field=pattern...]",
+				rawSelector,
+			)
+		}
+		condition, err := usecases.NewSelectorCondition(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+		if err != nil {
+			return usecases.Selector{}, errors.Wrapf(err, "invalid selector %q", rawSelector)
+		}
+		selector.Conditions = append(selector.Conditions, condition)
+	}
+	return selector, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+
+	"github.com/phoops/bellatrix/internal/core/usecases"
+	"github.com/phoops/bellatrix/internal/infrastructure/plan"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var statusCmd = &cobra.Command{
+	Run: func(cmd *cobra.Command, args []string) {
+		startStatus(cmd, args)
+	},
+	Use:   "status [CONFIG FILE]",
+	Short: "Show the health of the managed subscriptions, without changing them",
+}
+
+func init() {
+	statusCmd.Flags().StringP(outputFlagName, "o", outputText, "Output format, text or json")
+}
+
+func startStatus(cmd *cobra.Command, args []string) {
+	instancePrefix := getInstancePrefix(cmd)
+	logger := newLogger(getDebug(cmd))
+	ctx, cancel := newContext(cmd, logger)
+	defer cancel()
+
+	output, err := cmd.Flags().GetString(outputFlagName)
+	if err != nil {
+		panic(err)
+	}
+	if output != outputText && output != outputJSON {
+		logger.Fatal("Invalid output format, use text or json", zap.String("output", output))
+	}
+
+	stateFromFile := loadSubscriptionsState(logger, getStateFilePath(args), instancePrefix)
+	orionClient := newOrionClient(ctx, cmd, logger, stateFromFile.ClientOptions)
+
+	getSubscriptionsStatusUsecase := usecases.NewGetSubscriptionsStatus(
+		newGetAvailableSubscriptions(cmd, orionClient, logger),
+		newGetSubscriptionsHealth(cmd, orionClient, stateFromFile.ClientOptions, logger),
+		logger,
+		instancePrefix,
+		getParallelism(cmd),
+	)
+
+	reports, err := getSubscriptionsStatusUsecase.Execute(ctx, stateFromFile.SubscriptionsState)
+	if err != nil {
+		logger.Fatal("Error during the reading of the subscriptions status", zap.Error(err))
+	}
+
+	renderer := plan.NewRenderer(false)
+	if output == outputJSON {
+		err = renderer.RenderStatusJSON(os.Stdout, reports)
+	} else {
+		err = renderer.RenderStatus(os.Stdout, reports)
+	}
+	if err != nil {
+		logger.Fatal("Error during the rendering of the status", zap.Error(err))
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/phoops/bellatrix/internal/core/usecases"
+	"github.com/phoops/bellatrix/internal/infrastructure/plan"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var (
+	autoApproveFlagName    = "auto-approve"
+	autoApproveEnvVariable = "AUTO_APPROVE"
+)
+
+var syncCmd = &cobra.Command{
+	Run: func(cmd *cobra.Command, args []string) {
+		startBellatrix(cmd, args)
+	},
+	Use:   "sync [CONFIG FILE]",
+	Short: "Sync your orion subscriptions with your state file",
+}
+
+func init() {
+	syncCmd.Flags().Bool(autoApproveFlagName, false, "Apply the changes without asking for confirmation")
+	syncCmd.Flags().Bool(noColorFlagName, false, "Disable the colored text output of the changes to confirm")
+	addSelectionFlags(syncCmd)
+}
+
+func startBellatrix(cmd *cobra.Command, args []string) {
+	dryRun := getDryRun(cmd)
+	instancePrefix := getInstancePrefix(cmd)
+	logger := newLogger(getDebug(cmd))
+	ctx, cancel := newContext(cmd, logger)
+	defer cancel()
+	applyMode := getApplyMode(cmd, logger)
+	keepGoing := applyMode == usecases.ApplyModeKeepGoing
+	selection, err := getSelection(cmd)
+	if err != nil {
+		logger.Fatal("Error during the reading of the selectors", zap.Error(err))
+	}
+
+	stateFromFile := loadSubscriptionsState(logger, getStateFilePath(args), instancePrefix)
+	orionClient := newOrionClient(ctx, cmd, logger, stateFromFile.ClientOptions)
+
+	getAvailableSubscriptionsUsecase := newGetAvailableSubscriptions(cmd, orionClient, logger)
+	getSubscriptionsPatchesUsecase := usecases.NewGetSubscriptionsPatches(
+		getAvailableSubscriptionsUsecase,
+		logger,
+		instancePrefix,
+		getParallelism(cmd),
+		getDestroyGuard(cmd),
+		selection,
+	)
+	applySubscriptionsPatchesUsecase := newApplySubscriptionsPatches(
+		cmd,
+		orionClient,
+		getAvailableSubscriptionsUsecase,
+		logger,
+	)
+	ensureSubscriptionsAreActiveUsecase := usecases.NewEnsureSubscriptionsAreActive(
+		getAvailableSubscriptionsUsecase,
+		logger.Sugar(),
+		newCreateSubscription(cmd, orionClient, getAvailableSubscriptionsUsecase, logger),
+		newUpdateSubscription(cmd, orionClient, logger),
+		newDeleteSubscription(cmd, orionClient, logger),
+		newGetSubscriptionsHealth(cmd, orionClient, stateFromFile.ClientOptions, logger),
+		instancePrefix,
+		keepGoing,
+		false,
+		getParallelism(cmd),
+		selection,
+	)
+
+	patches, err := getSubscriptionsPatchesUsecase.Execute(ctx, stateFromFile.SubscriptionsState)
+	if err != nil {
+		fatalPatchesError(logger, err)
+	}
+
+	if !dryRun && hasChanges(patches) {
+		showPatches := func() error {
+			noColor, err := cmd.Flags().GetBool(noColorFlagName)
+			if err != nil {
+				panic(err)
+			}
+			return plan.NewRenderer(!noColor && isTerminal(os.Stdout)).RenderText(os.Stdout, patches)
+		}
+		if !approveChanges(ctx, cmd, logger, showPatches) {
+			logger.Info("Changes not approved, nothing applied")
+			return
+		}
+	}
+
+	if !dryRun {
+		var results []*entities.OperationResult
+		failed := false
+
+		applyResults, err := applySubscriptionsPatchesUsecase.Execute(ctx, patches)
+		results = append(results, applyResults...)
+
+		if err != nil {
+			if !keepGoing {
+				logger.Fatal("Error during patch execution", zap.Error(err))
+			}
+			logger.Error("Errors during patch execution", zap.Error(err))
+			failed = true
+		}
+
+		logger.Info("Ensuring the subscriptions are in the active state")
+		ensureResults, err := ensureSubscriptionsAreActiveUsecase.Execute(
+			ctx,
+			stateFromFile.SubscriptionsState,
+		)
+		results = append(results, ensureResults...)
+
+		if err != nil {
+			if !keepGoing {
+				logger.Fatal("Error during the ensuring of subscriptions active state", zap.Error(err))
+			}
+			logger.Error("Errors during the ensuring of subscriptions active state", zap.Error(err))
+			failed = true
+		}
+
+		if keepGoing {
+			err = plan.NewRenderer(false).RenderReport(os.Stdout, results)
+			if err != nil {
+				logger.Error("Error during the rendering of the report", zap.Error(err))
+			}
+		}
+
+		if failed {
+			logger.Fatal("Sync completed with errors, see the report")
+		}
+	}
+
+	logger.Info("Done, hope you had a nice sync :D")
+}
+
+func getAutoApprove(cmd *cobra.Command) bool {
+	autoApprove, err := cmd.Flags().GetBool(autoApproveFlagName)
+	if err != nil {
+		panic(err)
+	}
+	if !autoApprove {
+		// try for env variable
+		_, autoApprove = os.LookupEnv(autoApproveEnvVariable)
+	}
+	return autoApprove
+}
+
+// hasChanges reports if the patches contain a change to apply
+func hasChanges(patches []*entities.SubscriptionsPatch) bool {
+	for _, patch := range patches {
+		if !patch.IsEmpty() {
+			return true
+		}
+	}
+	return false
+}
+
+// approveChanges asks the operator to approve the changes, unless they are approved
+// in advance with --auto-approve, show prints them before the question when set.
+// Only an explicit yes is accepted. The signals are caught by the context,
+// so the answer is awaited until the context is done.
+func approveChanges(
+	ctx context.Context,
+	cmd *cobra.Command,
+	logger *zap.Logger,
+	show func() error,
+) bool {
+	if getAutoApprove(cmd) {
+		return true
+	}
+	if !isTerminal(os.Stdin) {
+		logger.Fatal("Changes need a confirmation but stdin is not a terminal, pass --auto-approve to apply them")
+	}
+	if show != nil {
+		if err := show(); err != nil {
+			logger.Fatal("Error during the rendering of the changes", zap.Error(err))
+		}
+	}
+
+	fmt.Print("\nDo you want to apply these changes? Only 'yes' will be accepted: ")
+	answers := make(chan string, 1)
+	go func() {
+		answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			answer = ""
+		}
+		answers <- answer
+	}()
+
+	select {
+	case answer := <-answers:
+		return strings.TrimSpace(answer) == "yes"
+	case <-ctx.Done():
+		fmt.Println()
+		logger.Fatal("Approval interrupted, nothing applied", zap.Error(ctx.Err()))
+		return false
+	}
+}
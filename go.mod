@@ -6,6 +6,8 @@ require (
 	github.com/phoops/ngsiv2 v0.5.2
 	github.com/pkg/errors v0.9.1
 	github.com/spf13/cobra v1.1.1
-	go.uber.org/multierr v1.6.0 // indirect
+	go.uber.org/multierr v1.6.0
 	go.uber.org/zap v1.16.0
+	golang.org/x/term v0.0.0-20201210144234-2321bbc49cbf
+	golang.org/x/time v0.0.0-20210220033141-f8bda1e9f3ba
 )
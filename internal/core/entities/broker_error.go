@@ -0,0 +1,23 @@
+package entities
+
+import "fmt"
+
+// BrokerError is returned by the requests made to the context broker without
+// the orion client, StatusCode is 0 when no complete response has been received,
+// like on network errors
+type BrokerError struct {
+	Operation  string
+	StatusCode int
+	Err        error
+}
+
+func (e *BrokerError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%s: context broker responded with status code %d: %v", e.Operation, e.StatusCode, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Operation, e.Err)
+}
+
+func (e *BrokerError) Unwrap() error {
+	return e.Err
+}
@@ -1,13 +1,84 @@
 package entities
 
-import "github.com/phoops/ngsiv2/model"
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/phoops/ngsiv2/model"
+)
 
 // SubscriptionRequest represent a request for a subscription
 // bellatrix will try to satisfy the requested state for the subscription
 type SubscriptionRequest struct {
-	ServicePath   string                `json:"service_path,omitempty"`
-	FiwareService string                `json:"fiware_service,omitempty"`
-	Subscriptions []*model.Subscription `json:"subscriptions"`
+	ServicePath   string                    `json:"service_path,omitempty"`
+	FiwareService string                    `json:"fiware_service,omitempty"`
+	Subscriptions []*SubscriptionDefinition `json:"subscriptions"`
+}
+
+// SubscriptionDefinition represent a subscription requested in the state file,
+// the orion subscription together with the options bellatrix uses to manage it.
+// PreventDestroy protects the subscription from deletions and replacements,
+// the protection must be removed with a separate change first.
+// Labels are not sent to the context broker, they are used only
+// to select the subscriptions a run works on.
+// FailurePolicy and HealStrategy replace the global ones of the state for the subscription
+type SubscriptionDefinition struct {
+	*model.Subscription
+	PreventDestroy bool              `json:"prevent_destroy,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	FailurePolicy  *FailurePolicy    `json:"failure_policy,omitempty"`
+	HealStrategy   string            `json:"heal_strategy,omitempty"`
+}
+
+const (
+	// HealStrategyReactivate patches a failed subscription back to active,
+	// with its requested notification, keeping its id
+	HealStrategyReactivate = "reactivate"
+	// HealStrategyRecreate deletes a failed subscription and creates it again
+	HealStrategyRecreate = "recreate"
+)
+
+// FailurePolicy decides when a subscription is failed and must be healed.
+// A failure is considered only when it is at least MinFailureAge old, newer than
+// the last success with FailureNewerThanSuccess, and when the context broker
+// reports failsCounter, after MinFailsCounter consecutive failures.
+// SuccessCodes are the last success codes that are not a failure,
+// by default the codes under 300
+type FailurePolicy struct {
+	MinFailureAge           *Duration `json:"min_failure_age,omitempty"`
+	FailureNewerThanSuccess bool      `json:"failure_newer_than_success,omitempty"`
+	SuccessCodes            []uint    `json:"success_codes,omitempty"`
+	MinFailsCounter         uint      `json:"min_fails_counter,omitempty"`
+}
+
+// Duration is a time.Duration written in the state file as a string, e.g. 10m
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(content []byte) error {
+	var value string
+	if err := json.Unmarshal(content, &value); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+	return nil
+}
+
+// SubscriptionHealth represent the notification fields reported by the context broker
+// and not exposed by the orion client, FailsCounter is nil when not reported
+type SubscriptionHealth struct {
+	ID                string `json:"id"`
+	FailsCounter      *uint  `json:"fails_counter,omitempty"`
+	LastFailureReason string `json:"last_failure_reason,omitempty"`
 }
 
 // OrionClientOptions represent options for the main orion client
@@ -20,14 +91,120 @@ type OrionClientOptions struct {
 
 // SubscriptionsRequestedState represent the main state you can request
 // in order to have the subscriptions synced with the context broker
+// ManagedScopes lists the fiware-service/service path couples managed by bellatrix,
+// a managed scope without a subscription request has all its managed subscriptions deleted.
+// FailurePolicy and HealStrategy apply to the subscriptions without their own
 type SubscriptionsRequestedState struct {
 	ClientOptions      OrionClientOptions    `json:"client_options"`
+	ManagedScopes      []SubscriptionsScope  `json:"managed_scopes,omitempty"`
+	FailurePolicy      *FailurePolicy        `json:"failure_policy,omitempty"`
+	HealStrategy       string                `json:"heal_strategy,omitempty"`
 	SubscriptionsState []SubscriptionRequest `json:"subscriptions_state"`
 }
 
+// SubscriptionFieldChange represent a single field of a subscription
+// that differs between the context broker and the requested state
+type SubscriptionFieldChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// SubscriptionUpdate represent a subscription that exists on the context broker
+// but with a content different from the requested one, so it must be patched in place
+type SubscriptionUpdate struct {
+	Current *model.Subscription        `json:"current"`
+	Desired *model.Subscription        `json:"desired"`
+	Changes []*SubscriptionFieldChange `json:"changes"`
+}
+
+// SkippedChange represent a change needed to sync a subscription
+// that is not applied because the subscription is outside the selection of the run
+type SkippedChange struct {
+	Operation      string `json:"operation"`
+	SubscriptionID string `json:"subscription_id,omitempty"`
+	Description    string `json:"description"`
+}
+
 type SubscriptionsPatch struct {
 	ServicePath           string                `json:"service_path,omitempty"`
 	FiwareService         string                `json:"fiware_service,omitempty"`
 	SubscriptionsToAdd    []*model.Subscription `json:"subscriptions_to_add"`
+	SubscriptionsToUpdate []*SubscriptionUpdate `json:"subscriptions_to_update"`
 	SubscriptionsToDelete []*model.Subscription `json:"subscriptions_to_delete"`
+	DuplicatesToDelete    []*model.Subscription `json:"duplicates_to_delete"`
+	Skipped               []*SkippedChange      `json:"skipped,omitempty"`
+	// InactiveSubscriptions are the subscriptions that went inactive on their own,
+	// they are only reported
+	InactiveSubscriptions []*model.Subscription `json:"inactive_subscriptions,omitempty"`
+}
+
+// IsEmpty reports if the patch does not contain any change to apply,
+// the skipped changes and the inactive subscriptions are not considered
+func (p *SubscriptionsPatch) IsEmpty() bool {
+	return len(p.SubscriptionsToAdd) == 0 &&
+		len(p.SubscriptionsToUpdate) == 0 &&
+		len(p.SubscriptionsToDelete) == 0 &&
+		len(p.DuplicatesToDelete) == 0
+}
+
+// SubscriptionsScope identify a fiware-service and service path couple on the context broker
+type SubscriptionsScope struct {
+	FiwareService string `json:"fiware_service,omitempty"`
+	ServicePath   string `json:"service_path,omitempty"`
+}
+
+// SubscriptionsPlan represent a set of patches computed against the context broker
+// and saved for a later apply, the fingerprint identifies the managed subscriptions
+// found on the context broker for the scopes when the plan was computed
+type SubscriptionsPlan struct {
+	FormatVersion  int                   `json:"format_version"`
+	CreatedAt      time.Time             `json:"created_at"`
+	InstancePrefix string                `json:"instance_prefix,omitempty"`
+	Scopes         []SubscriptionsScope  `json:"scopes"`
+	Fingerprint    string                `json:"fingerprint"`
+	Patches        []*SubscriptionsPatch `json:"patches"`
+}
+
+const (
+	OperationCreate     = "create"
+	OperationUpdate     = "update"
+	OperationDelete     = "delete"
+	OperationRecreate   = "recreate"
+	OperationReactivate = "reactivate"
+)
+
+// OperationResult represent the outcome of a single operation
+// bellatrix performed on a subscription of the context broker,
+// the heal operations report the evidence of the failure
+type OperationResult struct {
+	FiwareService  string `json:"fiware_service,omitempty"`
+	ServicePath    string `json:"service_path,omitempty"`
+	Operation      string `json:"operation"`
+	SubscriptionID string `json:"subscription_id,omitempty"`
+	Description    string `json:"description"`
+	Evidence       string `json:"evidence,omitempty"`
+	Err            error  `json:"-"`
+}
+
+// SubscriptionStatusReport represent the health of a managed subscription of a scope.
+// Failed reports if the heal step would act on the subscription, with the evidence
+// of the failure. A subscription requested in the state and not found on the
+// context broker is Missing, only its description is set
+type SubscriptionStatusReport struct {
+	FiwareService     string     `json:"fiware_service,omitempty"`
+	ServicePath       string     `json:"service_path,omitempty"`
+	SubscriptionID    string     `json:"subscription_id,omitempty"`
+	Description       string     `json:"description"`
+	Status            string     `json:"status,omitempty"`
+	TimesSent         uint       `json:"times_sent"`
+	LastNotification  *time.Time `json:"last_notification,omitempty"`
+	LastSuccess       *time.Time `json:"last_success,omitempty"`
+	LastFailure       *time.Time `json:"last_failure,omitempty"`
+	LastFailureReason string     `json:"last_failure_reason,omitempty"`
+	LastSuccessCode   *uint      `json:"last_success_code,omitempty"`
+	FailsCounter      *uint      `json:"fails_counter,omitempty"`
+	Failed            bool       `json:"failed"`
+	FailureEvidence   string     `json:"failure_evidence,omitempty"`
+	Missing           bool       `json:"missing"`
 }
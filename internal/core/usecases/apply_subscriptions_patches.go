@@ -1,80 +1,321 @@
 package usecases
 
 import (
+	"context"
+
 	"github.com/phoops/bellatrix/internal/core/entities"
-	"github.com/phoops/ngsiv2/client"
 	"github.com/phoops/ngsiv2/model"
 	"github.com/pkg/errors"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 )
 
+// ApplyMode defines how the patches are applied when an operation fails
+type ApplyMode int
+
+const (
+	// ApplyModeFailFast stops at the first failed operation
+	ApplyModeFailFast ApplyMode = iota
+	// ApplyModeTransactional stops at the first failed operation
+	// and rolls back the changes already applied
+	ApplyModeTransactional
+	// ApplyModeKeepGoing attempts every operation and reports all the failures at the end
+	ApplyModeKeepGoing
+)
+
 type ApplySubscriptionsPatches struct {
-	orionClient *client.NgsiV2Client
-	logger      *zap.Logger
+	createSubscription *CreateSubscription
+	updateSubscription *UpdateSubscription
+	deleteSubscription *DeleteSubscription
+	logger             *zap.Logger
+	mode               ApplyMode
+	parallelism        int
 }
 
-func NewApplySubscriptionsPatches(orionClient *client.NgsiV2Client, logger *zap.Logger) *ApplySubscriptionsPatches {
-	return &ApplySubscriptionsPatches{orionClient: orionClient, logger: logger}
+// NewApplySubscriptionsPatches returns a new configured ApplySubscriptionsPatches usecase,
+// the patches of different scopes are applied concurrently by at most parallelism workers
+func NewApplySubscriptionsPatches(
+	createSubscription *CreateSubscription,
+	updateSubscription *UpdateSubscription,
+	deleteSubscription *DeleteSubscription,
+	logger *zap.Logger,
+	mode ApplyMode,
+	parallelism int,
+) *ApplySubscriptionsPatches {
+	return &ApplySubscriptionsPatches{
+		createSubscription: createSubscription,
+		updateSubscription: updateSubscription,
+		deleteSubscription: deleteSubscription,
+		logger:             logger,
+		mode:               mode,
+		parallelism:        parallelism,
+	}
 }
 
+// Execute applies the patches and returns the result of every operation attempted.
+// When the context is done no other operation is started, the ones in flight complete,
+// in transactional mode the changes already applied are rolled back anyway.
 func (u *ApplySubscriptionsPatches) Execute(
+	ctx context.Context,
 	subscriptionsPatches []*entities.SubscriptionsPatch,
-) error {
+) ([]*entities.OperationResult, error) {
 	if len(subscriptionsPatches) == 0 {
 		u.logger.Info(
 			"Subscriptions state in sync. No changes needed.",
 		)
 
-		return nil
+		return nil, nil
 	}
-	// apply the patches to the service broker
-	for _, patch := range subscriptionsPatches {
-		err := u.applyAddSubscriptionsPatch(
-			patch.SubscriptionsToAdd,
-			patch.FiwareService,
-			patch.ServicePath,
-		)
 
-		if err != nil {
-			return err
+	// the patches of the same scope are applied in order by the same worker
+	groups := groupByScope(len(subscriptionsPatches), func(i int) entities.SubscriptionsScope {
+		return entities.SubscriptionsScope{
+			FiwareService: subscriptionsPatches[i].FiwareService,
+			ServicePath:   subscriptionsPatches[i].ServicePath,
+		}
+	})
+	runs := make([]*applyRun, len(groups))
+	tasks := make([]scopeTask, len(groups))
+	for g, group := range groups {
+		g, group := g, group
+		runs[g] = &applyRun{
+			ctx:       ctx,
+			journal:   &subscriptionsJournal{},
+			keepGoing: u.mode == ApplyModeKeepGoing,
 		}
+		tasks[g] = func(logger *zap.Logger) error {
+			runs[g].logger = logger
+			for _, i := range group {
+				err := u.applyPatch(subscriptionsPatches[i], runs[g])
+				if err != nil {
+					// in keep going mode the failures collected before
+					// the interruption are reported too
+					return multierr.Append(runs[g].errs, err)
+				}
+			}
+			return runs[g].errs
+		}
+	}
 
-		err = u.applyDeleteSubscriptionsPatch(
-			patch.SubscriptionsToDelete,
-			patch.FiwareService,
-			patch.ServicePath,
-		)
+	errs := runScopeTasks(ctx, tasks, u.parallelism, u.logger, u.mode != ApplyModeKeepGoing)
 
-		if err != nil {
-			return err
-		}
+	journal := &subscriptionsJournal{}
+	var results []*entities.OperationResult
+	for _, run := range runs {
+		journal.mutations = append(journal.mutations, run.journal.mutations...)
+		results = append(results, run.results...)
+	}
+
+	var err error
+	if u.mode == ApplyModeKeepGoing {
+		err = multierr.Combine(errs...)
+	} else {
+		err = firstError(errs)
+	}
+	if err == nil || u.mode != ApplyModeTransactional {
+		return results, err
+	}
+
+	u.logger.Error(
+		"Patch execution failed, rolling back the applied changes",
+		zap.Error(err),
+		zap.Int("changes_to_rollback", len(journal.mutations)),
+	)
+	rollbackErr := journal.rollback(
+		detachContext(ctx),
+		u.createSubscription,
+		u.updateSubscription,
+		u.deleteSubscription,
+		u.logger,
+	)
+	if rollbackErr != nil {
+		u.logger.Error(
+			"Rollback failed, the context broker needs a manual check",
+			zap.Error(rollbackErr),
+		)
+	} else {
+		u.logger.Info("Rollback succeeded, the context broker is in the state before the apply")
 	}
 
+	return results, &TransactionError{Err: err, RollbackErr: rollbackErr}
+}
+
+// applyRun collects the outcome of the operations applied on a scope
+type applyRun struct {
+	ctx       context.Context
+	journal   *subscriptionsJournal
+	results   []*entities.OperationResult
+	logger    *zap.Logger
+	keepGoing bool
+	errs      error
+}
+
+// interrupted returns an error when the run must not start other operations
+func (r *applyRun) interrupted() error {
+	if err := r.ctx.Err(); err != nil {
+		return errors.Wrap(err, "patch execution interrupted")
+	}
 	return nil
 }
 
+// complete records the result of an operation, the returned error
+// is not nil when the run must stop
+func (r *applyRun) complete(result *entities.OperationResult, err error) error {
+	result.Err = err
+	r.results = append(r.results, result)
+	if err == nil {
+		return nil
+	}
+	if r.keepGoing {
+		r.errs = multierr.Append(r.errs, err)
+		return nil
+	}
+	return err
+}
+
+func (u *ApplySubscriptionsPatches) applyPatch(
+	patch *entities.SubscriptionsPatch,
+	run *applyRun,
+) error {
+	err := u.applyAddSubscriptionsPatch(
+		patch.SubscriptionsToAdd,
+		patch.FiwareService,
+		patch.ServicePath,
+		run,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	err = u.applyUpdateSubscriptionsPatch(
+		patch.SubscriptionsToUpdate,
+		patch.FiwareService,
+		patch.ServicePath,
+		run,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	err = u.applyDeleteSubscriptionsPatch(
+		patch.SubscriptionsToDelete,
+		patch.FiwareService,
+		patch.ServicePath,
+		run,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return u.applyDeleteSubscriptionsPatch(
+		patch.DuplicatesToDelete,
+		patch.FiwareService,
+		patch.ServicePath,
+		run,
+	)
+}
+
 func (u *ApplySubscriptionsPatches) applyAddSubscriptionsPatch(
 	subs []*model.Subscription,
 	fiwareService string,
 	fiwareServicePath string,
+	run *applyRun,
 ) error {
 	for _, sub := range subs {
-		u.logger.Info(
+		if err := run.interrupted(); err != nil {
+			return err
+		}
+		run.logger.Info(
 			"Add patch, adding subscription",
 			zap.String("subscription_description", sub.Description),
 		)
-		_, err := u.orionClient.CreateSubscription(
-			sub,
-			client.SubscriptionSetFiwareService(fiwareService),
-			client.SubscriptionSetFiwareServicePath(fiwareServicePath),
-		)
+		id, err := u.createSubscription.Execute(run.ctx, fiwareService, fiwareServicePath, sub)
 
 		if err != nil {
-			return errors.Wrapf(
+			err = errors.Wrapf(
 				err,
 				"could not apply the add subscription patch for subscription with description %s",
 				sub.Description,
 			)
+		} else {
+			run.journal.record(&subscriptionMutation{
+				operation:      subscriptionCreated,
+				fiwareService:  fiwareService,
+				servicePath:    fiwareServicePath,
+				subscriptionID: id,
+				description:    sub.Description,
+			})
+		}
+
+		err = run.complete(&entities.OperationResult{
+			FiwareService:  fiwareService,
+			ServicePath:    fiwareServicePath,
+			Operation:      entities.OperationCreate,
+			SubscriptionID: id,
+			Description:    sub.Description,
+		}, err)
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *ApplySubscriptionsPatches) applyUpdateSubscriptionsPatch(
+	updates []*entities.SubscriptionUpdate,
+	fiwareService string,
+	fiwareServicePath string,
+	run *applyRun,
+) error {
+	for _, update := range updates {
+		if err := run.interrupted(); err != nil {
+			return err
+		}
+		run.logger.Info(
+			"Update patch, updating subscription",
+			zap.String("subscription_id", update.Current.Id),
+			zap.String("subscription_description", update.Desired.Description),
+			zap.Any("changes", update.Changes),
+		)
+		err := u.updateSubscription.Execute(
+			run.ctx,
+			fiwareService,
+			fiwareServicePath,
+			update.Current.Id,
+			subscriptionUpdateRequest(update.Desired),
+		)
+
+		if err != nil {
+			err = errors.Wrapf(
+				err,
+				"could not apply the update subscription patch for subscription with description %s",
+				update.Desired.Description,
+			)
+		} else {
+			run.journal.record(&subscriptionMutation{
+				operation:      subscriptionUpdated,
+				fiwareService:  fiwareService,
+				servicePath:    fiwareServicePath,
+				subscriptionID: update.Current.Id,
+				description:    update.Desired.Description,
+				previous:       update.Current,
+			})
+		}
+
+		err = run.complete(&entities.OperationResult{
+			FiwareService:  fiwareService,
+			ServicePath:    fiwareServicePath,
+			Operation:      entities.OperationUpdate,
+			SubscriptionID: update.Current.Id,
+			Description:    update.Desired.Description,
+		}, err)
+
+		if err != nil {
+			return err
 		}
 	}
 	return nil
@@ -84,26 +325,55 @@ func (u *ApplySubscriptionsPatches) applyDeleteSubscriptionsPatch(
 	subs []*model.Subscription,
 	fiwareService string,
 	fiwareServicePath string,
+	run *applyRun,
 ) error {
 	for _, sub := range subs {
-		u.logger.Info(
+		if err := run.interrupted(); err != nil {
+			return err
+		}
+		run.logger.Info(
 			"Delete patch, deleting subscription",
 			zap.String("subscription_id", sub.Id),
 			zap.String("subscription_description", sub.Description),
 		)
-		err := u.orionClient.DeleteSubscription(
-			sub.Id,
-			client.SubscriptionSetFiwareService(fiwareService),
-			client.SubscriptionSetFiwareServicePath(fiwareServicePath),
-		)
+		err := u.deleteSubscription.Execute(run.ctx, fiwareService, fiwareServicePath, sub.Id)
 
 		if err != nil {
-			return errors.Wrapf(
+			err = errors.Wrapf(
 				err,
 				"could not apply the delete subscription patch for subscription with description %s",
 				sub.Description,
 			)
+		} else {
+			run.journal.record(&subscriptionMutation{
+				operation:      subscriptionDeleted,
+				fiwareService:  fiwareService,
+				servicePath:    fiwareServicePath,
+				subscriptionID: sub.Id,
+				description:    sub.Description,
+				previous:       sub,
+			})
+		}
+
+		err = run.complete(&entities.OperationResult{
+			FiwareService:  fiwareService,
+			ServicePath:    fiwareServicePath,
+			Operation:      entities.OperationDelete,
+			SubscriptionID: sub.Id,
+			Description:    sub.Description,
+		}, err)
+
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
+
+// subscriptionUpdateRequest returns the body of the PATCH request for the desired
+// subscription, orion does not accept the id inside the body
+func subscriptionUpdateRequest(desired *model.Subscription) *model.Subscription {
+	updateRequest := *desired
+	updateRequest.Id = ""
+	return &updateRequest
+}
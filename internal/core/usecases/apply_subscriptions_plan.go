@@ -0,0 +1,77 @@
+package usecases
+
+import (
+	"context"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// ErrStalePlan is returned when the managed subscriptions on the context broker
+// have changed since the plan was computed
+var ErrStalePlan = errors.New("the subscriptions on the context broker have changed since the plan was computed")
+
+type ApplySubscriptionsPlan struct {
+	getSubscriptionsFingerprint *GetSubscriptionsFingerprint
+	applySubscriptionsPatches   *ApplySubscriptionsPatches
+	logger                      *zap.Logger
+	instancePrefix              string
+}
+
+func NewApplySubscriptionsPlan(
+	getSubscriptionsFingerprint *GetSubscriptionsFingerprint,
+	applySubscriptionsPatches *ApplySubscriptionsPatches,
+	logger *zap.Logger,
+	instancePrefix string,
+) *ApplySubscriptionsPlan {
+	return &ApplySubscriptionsPlan{
+		getSubscriptionsFingerprint: getSubscriptionsFingerprint,
+		applySubscriptionsPatches:   applySubscriptionsPatches,
+		logger:                      logger,
+		instancePrefix:              instancePrefix,
+	}
+}
+
+// Execute applies exactly the patches contained in the plan, after checking
+// the plan has been computed against the current subscriptions on the context broker
+func (u *ApplySubscriptionsPlan) Execute(
+	ctx context.Context,
+	plan *entities.SubscriptionsPlan,
+) ([]*entities.OperationResult, error) {
+	if plan.FormatVersion != SubscriptionsPlanFormatVersion {
+		return nil, errors.Errorf(
+			"unsupported plan format version %d, expected %d",
+			plan.FormatVersion,
+			SubscriptionsPlanFormatVersion,
+		)
+	}
+
+	if plan.InstancePrefix != u.instancePrefix {
+		return nil, errors.Errorf(
+			"the plan was computed with instance prefix %q, current instance prefix is %q",
+			plan.InstancePrefix,
+			u.instancePrefix,
+		)
+	}
+
+	fingerprint, err := u.getSubscriptionsFingerprint.Execute(ctx, plan.Scopes)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not compute the fingerprint of the subscriptions")
+	}
+
+	if fingerprint != plan.Fingerprint {
+		u.logger.Debug(
+			"Plan fingerprint mismatch",
+			zap.String("plan_fingerprint", plan.Fingerprint),
+			zap.String("current_fingerprint", fingerprint),
+		)
+		return nil, errors.Wrapf(
+			ErrStalePlan,
+			"plan created at %s is stale, compute a new plan",
+			plan.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+		)
+	}
+
+	return u.applySubscriptionsPatches.Execute(ctx, plan.Patches)
+}
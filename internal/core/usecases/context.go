@@ -0,0 +1,33 @@
+package usecases
+
+import (
+	"context"
+	"time"
+)
+
+// detachedContext keeps the values of its parent, without its deadline and cancellation,
+// it is used for the operations that must complete once started, like the
+// recreation of a deleted subscription
+type detachedContext struct {
+	parent context.Context
+}
+
+func detachContext(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
+
+func (c detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (c detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (c detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
@@ -0,0 +1,94 @@
+package usecases
+
+import (
+	"context"
+
+	"github.com/phoops/ngsiv2/client"
+	"github.com/phoops/ngsiv2/model"
+	"go.uber.org/zap"
+)
+
+type CreateSubscription struct {
+	orionClient               *client.NgsiV2Client
+	getAvailableSubscriptions *GetAvailableSubscriptions
+	retryPolicy               RetryPolicy
+	logger                    *zap.Logger
+}
+
+// NewCreateSubscription returns a new configured CreateSubscription usecase
+func NewCreateSubscription(
+	orionClient *client.NgsiV2Client,
+	getAvailableSubscriptions *GetAvailableSubscriptions,
+	retryPolicy RetryPolicy,
+	logger *zap.Logger,
+) *CreateSubscription {
+	return &CreateSubscription{
+		orionClient:               orionClient,
+		getAvailableSubscriptions: getAvailableSubscriptions,
+		retryPolicy:               retryPolicy,
+		logger:                    logger,
+	}
+}
+
+// Execute creates the subscription and returns its id.
+// A create is not idempotent: when it fails with a transient error the request
+// could have reached orion anyway, so before retrying the subscriptions of the scope
+// are listed again, and a subscription with the same description and content
+// is taken as the one created by the failed request.
+func (u *CreateSubscription) Execute(
+	ctx context.Context,
+	fiwareService string,
+	servicePath string,
+	subscription *model.Subscription,
+) (string, error) {
+	var id string
+	err := u.retryPolicy.retry(
+		ctx,
+		u.logger,
+		"create subscription "+subscription.Description,
+		func() error {
+			var err error
+			id, err = u.orionClient.CreateSubscription(
+				subscription,
+				client.SubscriptionSetFiwareService(fiwareService),
+				client.SubscriptionSetFiwareServicePath(servicePath),
+			)
+			return err
+		},
+		func(lastErr error) (bool, error) {
+			landedID, err := u.findCreatedSubscription(ctx, fiwareService, servicePath, subscription)
+			if err != nil || landedID == "" {
+				return false, err
+			}
+			u.logger.Info(
+				"Subscription created despite the error, not retrying",
+				zap.String("subscription_id", landedID),
+				zap.String("subscription_description", subscription.Description),
+				zap.NamedError("create_error", lastErr),
+			)
+			id = landedID
+			return true, nil
+		},
+	)
+
+	return id, err
+}
+
+func (u *CreateSubscription) findCreatedSubscription(
+	ctx context.Context,
+	fiwareService string,
+	servicePath string,
+	subscription *model.Subscription,
+) (string, error) {
+	subscriptionsInOrion, err := u.getAvailableSubscriptions.Execute(ctx, fiwareService, servicePath)
+	if err != nil {
+		return "", err
+	}
+	for _, inOrion := range subscriptionsInOrion {
+		if inOrion.Description == subscription.Description &&
+			len(getSubscriptionChanges(subscription, inOrion)) == 0 {
+			return inOrion.Id, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,59 @@
+package usecases
+
+import (
+	"context"
+	"time"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/pkg/errors"
+)
+
+// SubscriptionsPlanFormatVersion is the version of the saved plan format,
+// plans saved with a different version cannot be applied
+const SubscriptionsPlanFormatVersion = 1
+
+type CreateSubscriptionsPlan struct {
+	getSubscriptionsPatches     *GetSubscriptionsPatches
+	getSubscriptionsFingerprint *GetSubscriptionsFingerprint
+	instancePrefix              string
+}
+
+func NewCreateSubscriptionsPlan(
+	getSubscriptionsPatches *GetSubscriptionsPatches,
+	getSubscriptionsFingerprint *GetSubscriptionsFingerprint,
+	instancePrefix string,
+) *CreateSubscriptionsPlan {
+	return &CreateSubscriptionsPlan{
+		getSubscriptionsPatches:     getSubscriptionsPatches,
+		getSubscriptionsFingerprint: getSubscriptionsFingerprint,
+		instancePrefix:              instancePrefix,
+	}
+}
+
+func (u *CreateSubscriptionsPlan) Execute(
+	ctx context.Context,
+	requestedSubscriptions []entities.SubscriptionRequest,
+) (*entities.SubscriptionsPlan, error) {
+	scopes := getRequestsScopes(requestedSubscriptions)
+
+	// the fingerprint is computed before the patches, if the context broker changes
+	// in between the plan is considered stale on apply, never the opposite
+	fingerprint, err := u.getSubscriptionsFingerprint.Execute(ctx, scopes)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not compute the fingerprint of the subscriptions")
+	}
+
+	patches, err := u.getSubscriptionsPatches.Execute(ctx, requestedSubscriptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entities.SubscriptionsPlan{
+		FormatVersion:  SubscriptionsPlanFormatVersion,
+		CreatedAt:      time.Now().UTC(),
+		InstancePrefix: u.instancePrefix,
+		Scopes:         scopes,
+		Fingerprint:    fingerprint,
+		Patches:        patches,
+	}, nil
+}
@@ -0,0 +1,59 @@
+package usecases
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/phoops/ngsiv2/client"
+	"go.uber.org/zap"
+)
+
+type DeleteSubscription struct {
+	orionClient *client.NgsiV2Client
+	retryPolicy RetryPolicy
+	logger      *zap.Logger
+}
+
+// NewDeleteSubscription returns a new configured DeleteSubscription usecase
+func NewDeleteSubscription(
+	orionClient *client.NgsiV2Client,
+	retryPolicy RetryPolicy,
+	logger *zap.Logger,
+) *DeleteSubscription {
+	return &DeleteSubscription{
+		orionClient: orionClient,
+		retryPolicy: retryPolicy,
+		logger:      logger,
+	}
+}
+
+// Execute deletes the subscription. When a retried delete finds the subscription
+// already gone, the failed attempt reached orion, so the delete succeeded.
+func (u *DeleteSubscription) Execute(
+	ctx context.Context,
+	fiwareService string,
+	servicePath string,
+	id string,
+) error {
+	retried := false
+	return u.retryPolicy.retry(
+		ctx,
+		u.logger,
+		"delete subscription "+id,
+		func() error {
+			err := u.orionClient.DeleteSubscription(
+				id,
+				client.SubscriptionSetFiwareService(fiwareService),
+				client.SubscriptionSetFiwareServicePath(servicePath),
+			)
+			if statusCode, ok := brokerStatusCode(err); retried && ok && statusCode == http.StatusNotFound {
+				return nil
+			}
+			return err
+		},
+		func(lastErr error) (bool, error) {
+			retried = true
+			return false, nil
+		},
+	)
+}
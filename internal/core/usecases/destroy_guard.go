@@ -0,0 +1,116 @@
+package usecases
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/pkg/errors"
+)
+
+// DefaultMaxDeletionsPercent is the maximum percentage of the managed subscriptions
+// a single run can delete
+const DefaultMaxDeletionsPercent = 50
+
+// ErrDestructiveChanges is returned when the patches delete more subscriptions
+// than the destroy guard allows
+var ErrDestructiveChanges = errors.New("the patches delete more subscriptions than allowed")
+
+// DestroyGuard is the safety net against the deletions caused by a wrong state,
+// e.g. a typo in a fiware-service: the patches are refused when they delete more
+// than MaxDeletions subscriptions, or more than MaxDeletionsPercent of the managed ones,
+// or when a scope would lose all its subscriptions, unless AllowDestroy is set.
+// A zero threshold means no limit.
+// Subscriptions deleted to be recreated and duplicates are not counted,
+// neither are the scopes removed on purpose, requested without subscriptions.
+type DestroyGuard struct {
+	MaxDeletions        int
+	MaxDeletionsPercent float64
+	AllowDestroy        bool
+}
+
+// check verifies the patches, managedCounts is the number of managed subscriptions
+// found on the context broker in every scope, removedScopes are the scopes
+// whose subscriptions are deleted on purpose
+func (g DestroyGuard) check(
+	patches []*entities.SubscriptionsPatch,
+	managedCounts map[entities.SubscriptionsScope]int,
+	removedScopes map[entities.SubscriptionsScope]bool,
+	instancePrefix string,
+) error {
+	if g.AllowDestroy {
+		return nil
+	}
+
+	managed := 0
+	for scope, count := range managedCounts {
+		if !removedScopes[scope] {
+			managed += count
+		}
+	}
+
+	var violations []string
+	deletions := 0
+	for _, patch := range patches {
+		scope := entities.SubscriptionsScope{FiwareService: patch.FiwareService, ServicePath: patch.ServicePath}
+		if removedScopes[scope] {
+			continue
+		}
+		scopeDeletions := countDestroyedSubscriptions(patch, instancePrefix)
+		deletions += scopeDeletions
+
+		// the subscriptions added do not replace the ones the scope already has
+		kept := managedCounts[scope] - scopeDeletions - len(patch.DuplicatesToDelete)
+		if scopeDeletions > 0 && kept <= 0 {
+			violations = append(violations, fmt.Sprintf(
+				"fiwareService %s and servicePath %s would lose all its %d subscriptions",
+				patch.FiwareService,
+				patch.ServicePath,
+				scopeDeletions,
+			))
+		}
+	}
+
+	if g.MaxDeletions > 0 && deletions > g.MaxDeletions {
+		violations = append(violations, fmt.Sprintf(
+			"%d subscriptions would be deleted, over the maximum of %d",
+			deletions,
+			g.MaxDeletions,
+		))
+	}
+	if g.MaxDeletionsPercent > 0 && managed > 0 {
+		percent := float64(deletions) * 100 / float64(managed)
+		if percent > g.MaxDeletionsPercent {
+			violations = append(violations, fmt.Sprintf(
+				"%d of %d managed subscriptions (%.0f%%) would be deleted, over the maximum of %.0f%%",
+				deletions,
+				managed,
+				percent,
+				g.MaxDeletionsPercent,
+			))
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	return errors.Wrap(ErrDestructiveChanges, strings.Join(violations, "; "))
+}
+
+// countDestroyedSubscriptions counts the subscriptions deleted by the patch
+// and not recreated with the same description
+func countDestroyedSubscriptions(patch *entities.SubscriptionsPatch, instancePrefix string) int {
+	recreated := make(map[string]bool)
+	for _, sub := range patch.SubscriptionsToAdd {
+		name, _ := managedSubscriptionName(sub.Description, instancePrefix)
+		recreated[name] = true
+	}
+
+	destroyed := 0
+	for _, sub := range patch.SubscriptionsToDelete {
+		if name, _ := managedSubscriptionName(sub.Description, instancePrefix); !recreated[name] {
+			destroyed++
+		}
+	}
+	return destroyed
+}
@@ -0,0 +1,96 @@
+package usecases
+
+import (
+	"testing"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/phoops/ngsiv2/model"
+	"github.com/pkg/errors"
+)
+
+func TestDestroyGuard(t *testing.T) {
+	waste := entities.SubscriptionsScope{FiwareService: "Wolfsburg", ServicePath: "/WasteMGT"}
+	parking := entities.SubscriptionsScope{FiwareService: "Wolfsburg", ServicePath: "/Parking"}
+	managedSubscriptions := func(names ...string) []*model.Subscription {
+		var subscriptions []*model.Subscription
+		for _, name := range names {
+			subscriptions = append(subscriptions, &model.Subscription{
+				Description: BellatrixManagedSubscriptionsPrefix + name,
+			})
+		}
+		return subscriptions
+	}
+	patch := func(scope entities.SubscriptionsScope, deleted []*model.Subscription, added []*model.Subscription) *entities.SubscriptionsPatch {
+		return &entities.SubscriptionsPatch{
+			FiwareService:         scope.FiwareService,
+			ServicePath:           scope.ServicePath,
+			SubscriptionsToDelete: deleted,
+			SubscriptionsToAdd:    added,
+		}
+	}
+
+	tests := []struct {
+		name          string
+		patches       []*entities.SubscriptionsPatch
+		managedCounts map[entities.SubscriptionsScope]int
+		removedScopes map[entities.SubscriptionsScope]bool
+		refused       bool
+	}{
+		{
+			name:          "scope losing all its subscriptions",
+			patches:       []*entities.SubscriptionsPatch{patch(waste, managedSubscriptions("a", "b"), nil)},
+			managedCounts: map[entities.SubscriptionsScope]int{waste: 2, parking: 8},
+			refused:       true,
+		},
+		{
+			name:          "scope removed on purpose",
+			patches:       []*entities.SubscriptionsPatch{patch(waste, managedSubscriptions("a", "b"), nil)},
+			managedCounts: map[entities.SubscriptionsScope]int{waste: 2, parking: 8},
+			removedScopes: map[entities.SubscriptionsScope]bool{waste: true},
+		},
+		{
+			name:          "scope removed on purpose over the maximum percent",
+			patches:       []*entities.SubscriptionsPatch{patch(waste, managedSubscriptions("a", "b", "c"), nil)},
+			managedCounts: map[entities.SubscriptionsScope]int{waste: 3, parking: 1},
+			removedScopes: map[entities.SubscriptionsScope]bool{waste: true},
+		},
+		{
+			name: "scope replacing all its subscriptions with new ones",
+			patches: []*entities.SubscriptionsPatch{
+				patch(waste, managedSubscriptions("a", "b"), managedSubscriptions("c", "d")),
+			},
+			managedCounts: map[entities.SubscriptionsScope]int{waste: 2, parking: 8},
+			refused:       true,
+		},
+		{
+			name: "scope recreating its subscriptions",
+			patches: []*entities.SubscriptionsPatch{
+				patch(waste, managedSubscriptions("a", "b"), managedSubscriptions("a", "b")),
+			},
+			managedCounts: map[entities.SubscriptionsScope]int{waste: 2},
+		},
+		{
+			name:          "deletions over the maximum percent",
+			patches:       []*entities.SubscriptionsPatch{patch(waste, managedSubscriptions("a", "b", "c"), nil)},
+			managedCounts: map[entities.SubscriptionsScope]int{waste: 4, parking: 1},
+			refused:       true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := DestroyGuard{MaxDeletionsPercent: DefaultMaxDeletionsPercent}.check(
+				test.patches,
+				test.managedCounts,
+				test.removedScopes,
+				"",
+			)
+			if refused := errors.Cause(err) == ErrDestructiveChanges; refused != test.refused {
+				t.Fatalf("expected refused %v, got error %v", test.refused, err)
+			}
+			if !test.refused && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package usecases
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/phoops/ngsiv2/client"
+	"github.com/phoops/ngsiv2/model"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// errAPIResourcesDiscovered stops a request of the orion client once the api resources are discovered
+var errAPIResourcesDiscovered = errors.New("api resources discovered")
+
+// stopAfterDiscovery is a request option that always fails with errAPIResourcesDiscovered,
+// it is built by reflection since the parameters of the options are not exported by the client
+var stopAfterDiscovery = reflect.MakeFunc(
+	reflect.TypeOf(client.SubscriptionParamFunc(nil)),
+	func([]reflect.Value) []reflect.Value {
+		return []reflect.Value{reflect.ValueOf(&errAPIResourcesDiscovered).Elem()}
+	},
+).Interface().(client.SubscriptionParamFunc)
+
+type DiscoverAPIResources struct {
+	orionClient *client.NgsiV2Client
+	retryPolicy RetryPolicy
+	logger      *zap.Logger
+}
+
+// NewDiscoverAPIResources returns a new configured DiscoverAPIResources usecase
+func NewDiscoverAPIResources(
+	orionClient *client.NgsiV2Client,
+	retryPolicy RetryPolicy,
+	logger *zap.Logger,
+) *DiscoverAPIResources {
+	return &DiscoverAPIResources{
+		orionClient: orionClient,
+		retryPolicy: retryPolicy,
+		logger:      logger,
+	}
+}
+
+// Execute makes the orion client discover the api resources of the context broker, the client
+// does it on its first request in a way that is not safe for concurrent use.
+// The discovery is a GET /v2, it does not depend on a tenant: the subscriptions url is resolved
+// before the options of a request are applied, so an option that fails stops the request
+// right after the discovery, nothing else is sent to the context broker
+func (u *DiscoverAPIResources) Execute(ctx context.Context) error {
+	return u.retryPolicy.retry(
+		ctx,
+		u.logger,
+		"discover api resources",
+		func() error {
+			err := u.orionClient.UpdateSubscription(
+				"discovery",
+				&model.Subscription{},
+				stopAfterDiscovery,
+			)
+			if err == errAPIResourcesDiscovered {
+				return nil
+			}
+			return err
+		},
+		nil,
+	)
+}
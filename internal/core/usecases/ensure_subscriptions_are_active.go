@@ -1,146 +1,399 @@
 package usecases
 
 import (
+	"context"
+	"time"
+
 	"github.com/phoops/bellatrix/internal/core/entities"
-	"github.com/phoops/ngsiv2/client"
 	"github.com/phoops/ngsiv2/model"
 	"github.com/pkg/errors"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 )
 
 type EnsureSubscriptionsAreActive struct {
 	getAvailableSubscriptions *GetAvailableSubscriptions
-	orionClient               *client.NgsiV2Client
+	createSubscription        *CreateSubscription
+	updateSubscription        *UpdateSubscription
+	deleteSubscription        *DeleteSubscription
+	getSubscriptionsHealth    *GetSubscriptionsHealth
 	logger                    *zap.SugaredLogger
 	instancePrefix            string
+	keepGoing                 bool
+	dryRun                    bool
+	parallelism               int
+	selection                 Selection
 }
 
+// NewEnsureSubscriptionsAreActive returns a new configured EnsureSubscriptionsAreActive usecase,
+// with keepGoing every failed subscription is attempted and the errors are reported at the end,
+// with dryRun the failed subscriptions are only reported,
+// the scopes are checked concurrently by at most parallelism workers,
+// only the subscriptions in the selection are recreated, the failsCounter is read
+// with getSubscriptionsHealth when a failure policy needs it
 func NewEnsureSubscriptionsAreActive(
 	getAvailableSubscriptions *GetAvailableSubscriptions,
 	logger *zap.SugaredLogger,
-	client *client.NgsiV2Client,
+	createSubscription *CreateSubscription,
+	updateSubscription *UpdateSubscription,
+	deleteSubscription *DeleteSubscription,
+	getSubscriptionsHealth *GetSubscriptionsHealth,
 	instancePrefix string,
+	keepGoing bool,
+	dryRun bool,
+	parallelism int,
+	selection Selection,
 ) *EnsureSubscriptionsAreActive {
-	return &EnsureSubscriptionsAreActive{instancePrefix: instancePrefix, getAvailableSubscriptions: getAvailableSubscriptions, logger: logger, orionClient: client}
+	return &EnsureSubscriptionsAreActive{
+		instancePrefix:            instancePrefix,
+		getAvailableSubscriptions: getAvailableSubscriptions,
+		logger:                    logger,
+		createSubscription:        createSubscription,
+		updateSubscription:        updateSubscription,
+		deleteSubscription:        deleteSubscription,
+		getSubscriptionsHealth:    getSubscriptionsHealth,
+		keepGoing:                 keepGoing,
+		dryRun:                    dryRun,
+		parallelism:               parallelism,
+		selection:                 selection,
+	}
 }
 
+// Execute heals the failed subscriptions, reactivating or recreating them as their heal strategy
+// requests, and returns the result of every heal attempted.
+// When the context is done no other heal is started, a subscription already
+// deleted is always recreated, so it is never left missing.
 func (u *EnsureSubscriptionsAreActive) Execute(
+	ctx context.Context,
 	requestedSubscriptions []entities.SubscriptionRequest,
-) error {
-	for _, request := range requestedSubscriptions {
-		subscriptionsInOrion, err := u.getAvailableSubscriptions.Execute(
-			request.FiwareService,
+) ([]*entities.OperationResult, error) {
+	groups := groupByScope(len(requestedSubscriptions), func(i int) entities.SubscriptionsScope {
+		return requestScope(requestedSubscriptions[i])
+	})
+	groupsResults := make([][]*entities.OperationResult, len(groups))
+	tasks := make([]scopeTask, len(groups))
+	for g, group := range groups {
+		g, group := g, group
+		tasks[g] = func(logger *zap.Logger) error {
+			var errs error
+			for _, i := range group {
+				results, err := u.ensureRequestIsActive(ctx, requestedSubscriptions[i], logger.Sugar())
+				groupsResults[g] = append(groupsResults[g], results...)
+				if err != nil {
+					if !u.keepGoing {
+						return err
+					}
+					errs = multierr.Append(errs, err)
+				}
+			}
+			return errs
+		}
+	}
+
+	errs := runScopeTasks(ctx, tasks, u.parallelism, u.logger.Desugar(), !u.keepGoing)
+
+	var results []*entities.OperationResult
+	for _, groupResults := range groupsResults {
+		results = append(results, groupResults...)
+	}
+	if u.keepGoing {
+		return results, multierr.Combine(errs...)
+	}
+	return results, firstError(errs)
+}
+
+func (u *EnsureSubscriptionsAreActive) ensureRequestIsActive(
+	ctx context.Context,
+	request entities.SubscriptionRequest,
+	logger *zap.SugaredLogger,
+) ([]*entities.OperationResult, error) {
+	var results []*entities.OperationResult
+	var errs error
+
+	subscriptionsInOrion, err := u.getAvailableSubscriptions.Execute(
+		ctx,
+		request.FiwareService,
+		request.ServicePath,
+	)
+
+	orionSubsManagedByBellatrix := getSubscriptionsManagedByBellatrix(subscriptionsInOrion, u.instancePrefix)
+
+	if err != nil {
+		return nil, errors.Wrapf(
+			err,
+			"could not get subscriptions on context broker for servicePath %s, and fiwareService %s, during ensure subscriptions are active",
 			request.ServicePath,
+			request.FiwareService,
 		)
+	}
 
-		orionSubsManagedByBellatrix := getSubscriptionsManagedByBellatrix(subscriptionsInOrion, u.instancePrefix)
+	// the health is read once per scope, only when a subscription looks failed,
+	// it completes the evidence and the failure policy checks on the fails counter
+	var subscriptionsHealth map[string]*entities.SubscriptionHealth
 
-		if err != nil {
-			return errors.Wrapf(
-				err,
-				"could not get subscriptions on context broker for servicePath %s, and fiwareService %s, during ensure subscriptions are active",
-				request.ServicePath,
-				request.FiwareService,
+	for _, subsForServicePath := range orionSubsManagedByBellatrix {
+		// the definition is nil when the subscription is not in the state
+		definition, _ := findSubscriptionInsideSubState(
+			request.Subscriptions,
+			subsForServicePath.Description,
+			u.instancePrefix,
+		)
+		evidence := subscriptionFailure(
+			subsForServicePath,
+			definition,
+			subscriptionsHealth[subsForServicePath.Id],
+			time.Now(),
+		)
+		if evidence == "" {
+			continue
+		}
+		if subscriptionsHealth == nil {
+			subscriptionsHealth, err = u.getSubscriptionsHealth.Execute(ctx, request.FiwareService, request.ServicePath)
+			if err != nil {
+				return results, multierr.Append(errs, errors.Wrapf(
+					err,
+					"could not get subscriptions health for servicePath %s, and fiwareService %s, during ensure subscriptions are active",
+					request.ServicePath,
+					request.FiwareService,
+				))
+			}
+			evidence = subscriptionFailure(
+				subsForServicePath,
+				definition,
+				subscriptionsHealth[subsForServicePath.Id],
+				time.Now(),
+			)
+			if evidence == "" {
+				continue
+			}
+		}
+		if !u.isSelected(request, subsForServicePath, definition) {
+			logger.Infow(
+				"Failed subscription outside the selection, skipped",
+				"subscription_id",
+				subsForServicePath.Id,
+				"name",
+				subsForServicePath.Description,
 			)
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return results, multierr.Append(errs, errors.Wrap(err, "ensure subscriptions are active interrupted"))
 		}
 
-		for _, subsForServicePath := range orionSubsManagedByBellatrix {
-			if isSubscriptionFailed(subsForServicePath) {
-				u.logger.Warnw(
-					"Subscription is in failed state, need to recreate.",
-					"subscription_id",
-					subsForServicePath.Id,
-					"failure_date",
-					subsForServicePath.Notification.LastFailure,
-				)
+		// a protected subscription is never deleted, it is reactivated whatever its heal strategy
+		operation := entities.OperationReactivate
+		if healStrategy(definition) == entities.HealStrategyRecreate && !isSubscriptionProtected(subsForServicePath, u.instancePrefix) {
+			operation = entities.OperationRecreate
+		}
+		if u.dryRun {
+			logger.Infow(
+				"Dry run, failed subscription not healed",
+				"subscription_id",
+				subsForServicePath.Id,
+				"name",
+				subsForServicePath.Description,
+				"operation",
+				operation,
+				"evidence",
+				evidence,
+			)
+			results = append(results, &entities.OperationResult{
+				FiwareService:  request.FiwareService,
+				ServicePath:    request.ServicePath,
+				Operation:      operation,
+				SubscriptionID: subsForServicePath.Id,
+				Description:    subsForServicePath.Description,
+				Evidence:       evidence,
+			})
+			continue
+		}
 
-				// delete subscription than recreate
+		if operation == entities.OperationRecreate {
+			// the delete and the create are a single change, once the delete
+			// is started the create must complete even if the context is done
+			err = u.recreateFailedSubscription(detachContext(ctx), request, subsForServicePath, definition, evidence, logger)
+		} else {
+			err = u.reactivateFailedSubscription(ctx, request, subsForServicePath, definition, evidence, logger)
+		}
+		results = append(results, &entities.OperationResult{
+			FiwareService:  request.FiwareService,
+			ServicePath:    request.ServicePath,
+			Operation:      operation,
+			SubscriptionID: subsForServicePath.Id,
+			Description:    subsForServicePath.Description,
+			Evidence:       evidence,
+			Err:            err,
+		})
 
-				err := u.orionClient.DeleteSubscription(
-					subsForServicePath.Id,
-					client.SubscriptionSetFiwareService(request.FiwareService),
-					client.SubscriptionSetFiwareServicePath(request.ServicePath),
-				)
+		if err != nil {
+			if !u.keepGoing {
+				return results, err
+			}
+			errs = multierr.Append(errs, err)
+		}
+	}
 
-				if err != nil {
-					return errors.Wrapf(
-						err,
-						"could not delete failed subscriptions with id %s - name: %s",
-						subsForServicePath.Id,
-						subsForServicePath.Description,
-					)
-				}
+	return results, errs
+}
 
-				u.logger.Infow(
-					"Deleted failed subscription",
-					"subscription_id",
-					subsForServicePath.Id,
-					"failure_date",
-					subsForServicePath.Notification.LastFailure,
-					"last_success_code",
-					subsForServicePath.Notification.LastSuccessCode,
-				)
-
-				subInState, err := findSubscriptionInsideSubState(
-					request.Subscriptions,
-					subsForServicePath.Description,
-				)
+// reactivateFailedSubscription patches the failed subscription back to its requested state,
+// the subscription keeps its id and there is no moment without it
+func (u *EnsureSubscriptionsAreActive) reactivateFailedSubscription(
+	ctx context.Context,
+	request entities.SubscriptionRequest,
+	subsForServicePath *model.Subscription,
+	definition *entities.SubscriptionDefinition,
+	evidence string,
+	logger *zap.SugaredLogger,
+) error {
+	logger.Warnw(
+		"Subscription is in failed state, need to reactivate.",
+		"subscription_id",
+		subsForServicePath.Id,
+		"evidence",
+		evidence,
+	)
 
-				if err != nil {
-					return errors.Wrapf(
-						err,
-						"could not found subscription to recreate in state - name: %s",
-						subsForServicePath.Description,
-					)
-				}
+	err := u.updateSubscription.Execute(
+		ctx,
+		request.FiwareService,
+		request.ServicePath,
+		subsForServicePath.Id,
+		reactivationRequest(definition),
+	)
 
-				newSubscription := &model.Subscription{
-					Description:  subsForServicePath.Description,
-					Subject:      subInState.Subject,
-					Notification: subInState.Notification,
-				}
+	if err != nil {
+		return errors.Wrapf(
+			err,
+			"could not reactivate failed subscription with id %s - name: %s",
+			subsForServicePath.Id,
+			subsForServicePath.Description,
+		)
+	}
 
-				_, err = u.orionClient.CreateSubscription(
-					newSubscription,
-					client.SubscriptionSetFiwareService(request.FiwareService),
-					client.SubscriptionSetFiwareServicePath(request.ServicePath),
-				)
+	logger.Infow(
+		"Reactivated failed subscription",
+		"subscription_id",
+		subsForServicePath.Id,
+		"name",
+		subsForServicePath.Description,
+	)
 
-				if err != nil {
-					return errors.Wrapf(
-						err,
-						"could not recreate failed subscription with id %s - name: %s",
-						subsForServicePath.Id,
-						subsForServicePath.Description,
-					)
-				}
+	return nil
+}
 
-				u.logger.Infow(
-					"Recreated failed subscription",
-					"name",
-					subsForServicePath.Description,
-				)
-			}
+// recreateFailedSubscription deletes the failed subscription and creates it again from its
+// complete definition in the state, the same request the apply of an add patch sends,
+// a subscription not in the state is not deleted since it could not be recreated
+func (u *EnsureSubscriptionsAreActive) recreateFailedSubscription(
+	ctx context.Context,
+	request entities.SubscriptionRequest,
+	subsForServicePath *model.Subscription,
+	definition *entities.SubscriptionDefinition,
+	evidence string,
+	logger *zap.SugaredLogger,
+) error {
+	if definition == nil {
+		return errors.Errorf(
+			"could not found subscription to recreate in state - name: %s",
+			subsForServicePath.Description,
+		)
+	}
 
-		}
+	logger.Warnw(
+		"Subscription is in failed state, need to recreate.",
+		"subscription_id",
+		subsForServicePath.Id,
+		"evidence",
+		evidence,
+		"failure_date",
+		subsForServicePath.Notification.LastFailure,
+	)
+
+	// delete subscription than recreate
 
+	err := u.deleteSubscription.Execute(
+		ctx,
+		request.FiwareService,
+		request.ServicePath,
+		subsForServicePath.Id,
+	)
+
+	if err != nil {
+		return errors.Wrapf(
+			err,
+			"could not delete failed subscriptions with id %s - name: %s",
+			subsForServicePath.Id,
+			subsForServicePath.Description,
+		)
 	}
+
+	logger.Infow(
+		"Deleted failed subscription",
+		"subscription_id",
+		subsForServicePath.Id,
+		"failure_date",
+		subsForServicePath.Notification.LastFailure,
+		"last_success_code",
+		subsForServicePath.Notification.LastSuccessCode,
+	)
+
+	_, err = u.createSubscription.Execute(
+		ctx,
+		request.FiwareService,
+		request.ServicePath,
+		definition.Subscription,
+	)
+
+	if err != nil {
+		return errors.Wrapf(
+			err,
+			"could not recreate failed subscription with id %s - name: %s",
+			subsForServicePath.Id,
+			subsForServicePath.Description,
+		)
+	}
+
+	logger.Infow(
+		"Recreated failed subscription",
+		"name",
+		definition.Description,
+	)
+
 	return nil
 }
 
-// We need to check for last failure date, and for the last success code
-// because @telefonica 404 MEANS SUCCESS
-func isSubscriptionFailed(subscription *model.Subscription) bool {
-	return (subscription.Notification != nil && subscription.Notification.LastFailure != nil) ||
-		(subscription.Notification != nil && subscription.Notification.LastSuccessCode != nil && *subscription.Notification.LastSuccessCode >= 300)
+// isSelected reports if the subscription is in the selection of the run,
+// the labels are taken from its definition in the state, nil when it is not in the state
+func (u *EnsureSubscriptionsAreActive) isSelected(
+	request entities.SubscriptionRequest,
+	subscription *model.Subscription,
+	definition *entities.SubscriptionDefinition,
+) bool {
+	if u.selection.isEmpty() {
+		return true
+	}
+	name, _ := managedSubscriptionName(subscription.Description, u.instancePrefix)
+	var labels map[string]string
+	if definition != nil {
+		labels = definition.Labels
+	}
+	return u.selection.selects(selectedSubscription{
+		scope:       requestScope(request),
+		description: name,
+		labels:      labels,
+	})
 }
 
 func findSubscriptionInsideSubState(
-	subscriptionsInState []*model.Subscription,
+	subscriptionsInState []*entities.SubscriptionDefinition,
 	subscriptionDescription string,
-) (*model.Subscription, error) {
+	instancePrefix string,
+) (*entities.SubscriptionDefinition, error) {
+	name, _ := managedSubscriptionName(subscriptionDescription, instancePrefix)
 	for _, subInState := range subscriptionsInState {
-		if subInState.Description == subscriptionDescription {
+		if nameInState, _ := managedSubscriptionName(subInState.Description, instancePrefix); nameInState == name {
 			return subInState, nil
 		}
 	}
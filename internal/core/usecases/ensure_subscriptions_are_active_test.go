@@ -0,0 +1,170 @@
+package usecases
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+)
+
+// unknownHealthReader reports no health, like a context broker
+// not exposing failsCounter and lastFailureReason
+type unknownHealthReader struct{}
+
+func (unknownHealthReader) ReadSubscriptionsHealthPage(
+	ctx context.Context,
+	fiwareService string,
+	servicePath string,
+	limit int,
+	offset int,
+) ([]*entities.SubscriptionHealth, int, error) {
+	return nil, 0, nil
+}
+
+func TestHealRecreatesWithTheRequestOfSync(t *testing.T) {
+	requestedState := func() []entities.SubscriptionRequest {
+		return []entities.SubscriptionRequest{{
+			FiwareService: "Wolfsburg",
+			ServicePath:   "/WasteMGT",
+			Subscriptions: []*entities.SubscriptionDefinition{{
+				Subscription: mustSubscription(t, `{
+					"description": "`+BellatrixManagedSubscriptionsPrefix+`waste collection",
+					"subject": {"entities": [{"idPattern": ".*", "type": "WasteCollection"}]},
+					"notification": {
+						"httpCustom": {"url": "http://consumer/notify", "headers": {"b": "2", "a": "1"}},
+						"attrs": ["fillingLevel"],
+						"attrsFormat": "keyValues"
+					},
+					"expires": "2040-01-01T16:00:00+02:00",
+					"throttling": 5,
+					"status": "active"
+				}`),
+				HealStrategy: entities.HealStrategyRecreate,
+			}},
+		}}
+	}
+
+	// sync creates the subscription missing from the context broker
+	syncBroker := newFakeBroker(t)
+	syncUsecases := newBrokerUsecases(t, syncBroker)
+	patches, err := NewGetSubscriptionsPatches(
+		syncUsecases.getAvailableSubscriptions,
+		testLogger(),
+		"",
+		1,
+		DestroyGuard{},
+		Selection{},
+	).Execute(context.Background(), requestedState())
+	if err != nil {
+		t.Fatalf("unexpected error computing the patches: %v", err)
+	}
+	_, err = NewApplySubscriptionsPatches(
+		syncUsecases.createSubscription,
+		syncUsecases.updateSubscription,
+		syncUsecases.deleteSubscription,
+		testLogger(),
+		ApplyModeFailFast,
+		1,
+	).Execute(context.Background(), patches)
+	if err != nil {
+		t.Fatalf("unexpected error applying the patches: %v", err)
+	}
+
+	// heal recreates the same subscription found failed on the context broker
+	healBroker := newFakeBroker(t)
+	healBroker.addSubscription(t, mustSubscription(t, `{
+		"description": "`+BellatrixManagedSubscriptionsPrefix+`waste collection",
+		"notification": {"http": {"url": "http://consumer/notify"}, "lastFailure": "2040-01-01T10:00:00.000Z"}
+	}`))
+	healUsecases := newBrokerUsecases(t, healBroker)
+	results, err := NewEnsureSubscriptionsAreActive(
+		healUsecases.getAvailableSubscriptions,
+		testLogger().Sugar(),
+		healUsecases.createSubscription,
+		healUsecases.updateSubscription,
+		healUsecases.deleteSubscription,
+		NewGetSubscriptionsHealth(unknownHealthReader{}, 0, 0, testRetryPolicy(), testLogger()),
+		"",
+		false,
+		false,
+		1,
+		Selection{},
+	).Execute(context.Background(), requestedState())
+	if err != nil {
+		t.Fatalf("unexpected error healing the subscriptions: %v", err)
+	}
+	if len(results) != 1 || results[0].Operation != entities.OperationRecreate {
+		t.Fatalf("expected the failed subscription to be recreated, got %+v", results)
+	}
+
+	if len(syncBroker.createBodies) != 1 || len(healBroker.createBodies) != 1 {
+		t.Fatalf(
+			"expected one create request each, got %d from sync and %d from heal",
+			len(syncBroker.createBodies),
+			len(healBroker.createBodies),
+		)
+	}
+	if !bytes.Equal(syncBroker.createBodies[0], healBroker.createBodies[0]) {
+		t.Fatalf(
+			"create requests differ\nsync: %s\nheal: %s",
+			syncBroker.createBodies[0],
+			healBroker.createBodies[0],
+		)
+	}
+	for _, field := range []string{`"expires"`, `"throttling"`, `"status"`} {
+		if !bytes.Contains(healBroker.createBodies[0], []byte(field)) {
+			t.Fatalf("field %s missing from the create request %s", field, healBroker.createBodies[0])
+		}
+	}
+}
+
+func TestHealReactivatesProtectedSubscriptions(t *testing.T) {
+	broker := newFakeBroker(t)
+	broker.addSubscription(t, mustSubscription(t, `{
+		"description": "`+BellatrixProtectedSubscriptionsPrefix+`waste collection",
+		"notification": {"http": {"url": "http://consumer/notify"}, "lastFailure": "2040-01-01T10:00:00.000Z"},
+		"status": "failed"
+	}`))
+	requestedState := []entities.SubscriptionRequest{{
+		FiwareService: "Wolfsburg",
+		ServicePath:   "/WasteMGT",
+		Subscriptions: []*entities.SubscriptionDefinition{{
+			Subscription: mustSubscription(t, `{
+				"description": "`+BellatrixProtectedSubscriptionsPrefix+`waste collection",
+				"notification": {"http": {"url": "http://consumer/notify"}}
+			}`),
+			PreventDestroy: true,
+			HealStrategy:   entities.HealStrategyRecreate,
+		}},
+	}}
+
+	scopeUsecases := newBrokerUsecases(t, broker)
+	results, err := NewEnsureSubscriptionsAreActive(
+		scopeUsecases.getAvailableSubscriptions,
+		testLogger().Sugar(),
+		scopeUsecases.createSubscription,
+		scopeUsecases.updateSubscription,
+		scopeUsecases.deleteSubscription,
+		NewGetSubscriptionsHealth(unknownHealthReader{}, 0, 0, testRetryPolicy(), testLogger()),
+		"",
+		false,
+		false,
+		1,
+		Selection{},
+	).Execute(context.Background(), requestedState)
+	if err != nil {
+		t.Fatalf("unexpected error healing the subscriptions: %v", err)
+	}
+	if len(results) != 1 || results[0].Operation != entities.OperationReactivate {
+		t.Fatalf("expected the protected subscription to be reactivated, got %+v", results)
+	}
+	if len(broker.createBodies) != 0 {
+		t.Fatalf("expected the protected subscription not to be recreated, got %d create requests", len(broker.createBodies))
+	}
+
+	healed := broker.subscription(t, BellatrixProtectedSubscriptionsPrefix+"waste collection")
+	if healed == nil || healed.Id != results[0].SubscriptionID || healed.Status != "active" {
+		t.Fatalf("expected the protected subscription to keep its id and be active, got %+v", healed)
+	}
+}
@@ -0,0 +1,92 @@
+package usecases
+
+import (
+	"context"
+	"sort"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/pkg/errors"
+)
+
+// ExportSubscriptionsState builds a state from the subscriptions found on the
+// context broker, syncing the exported state on the same context broker is a no-op
+type ExportSubscriptionsState struct {
+	getAvailableSubscriptions *GetAvailableSubscriptions
+	instancePrefix            string
+}
+
+func NewExportSubscriptionsState(
+	getAvailableSubscriptions *GetAvailableSubscriptions,
+	instancePrefix string,
+) *ExportSubscriptionsState {
+	return &ExportSubscriptionsState{getAvailableSubscriptions: getAvailableSubscriptions, instancePrefix: instancePrefix}
+}
+
+// Execute exports the managed subscriptions of the scopes, when includeUnmanaged
+// is set the other subscriptions are exported too, syncing them creates managed copies
+func (u *ExportSubscriptionsState) Execute(
+	ctx context.Context,
+	scopes []entities.SubscriptionsScope,
+	clientOptions entities.OrionClientOptions,
+	includeUnmanaged bool,
+) (*entities.SubscriptionsRequestedState, error) {
+	subsState := &entities.SubscriptionsRequestedState{
+		ClientOptions:      clientOptions,
+		SubscriptionsState: []entities.SubscriptionRequest{},
+	}
+
+	for _, scope := range scopes {
+		subscriptionsInOrion, err := u.getAvailableSubscriptions.Execute(
+			ctx,
+			scope.FiwareService,
+			scope.ServicePath,
+		)
+
+		if err != nil {
+			return nil, errors.Wrapf(
+				err,
+				"could not get subscriptions on context broker for servicePath %s, and fiwareService %s, during export",
+				scope.ServicePath,
+				scope.FiwareService,
+			)
+		}
+
+		subscriptionsToExport := subscriptionsInOrion
+		if !includeUnmanaged {
+			subscriptionsToExport = getSubscriptionsManagedByBellatrix(subscriptionsInOrion, u.instancePrefix)
+		}
+
+		request := entities.SubscriptionRequest{
+			FiwareService: scope.FiwareService,
+			ServicePath:   scope.ServicePath,
+			Subscriptions: []*entities.SubscriptionDefinition{},
+		}
+		descriptions := make(map[string]string)
+		for _, sub := range subscriptionsToExport {
+			definition := stateSubscription(sub, u.instancePrefix)
+			if definition.Description == "" {
+				return nil, errors.Errorf(
+					"subscription with id %s has no description, it cannot be exported",
+					sub.Id,
+				)
+			}
+			if otherID, ok := descriptions[definition.Description]; ok {
+				return nil, errors.Errorf(
+					"subscriptions with ids %s and %s have the same description %s, it cannot be exported",
+					otherID,
+					sub.Id,
+					definition.Description,
+				)
+			}
+			descriptions[definition.Description] = sub.Id
+			request.Subscriptions = append(request.Subscriptions, definition)
+		}
+		sort.Slice(request.Subscriptions, func(i, j int) bool {
+			return request.Subscriptions[i].Description < request.Subscriptions[j].Description
+		})
+
+		subsState.SubscriptionsState = append(subsState.SubscriptionsState, request)
+	}
+
+	return subsState, nil
+}
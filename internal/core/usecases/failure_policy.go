@@ -0,0 +1,111 @@
+package usecases
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/phoops/ngsiv2/model"
+	"github.com/pkg/errors"
+)
+
+// subscriptionFailure returns the evidence of the failure of the subscription,
+// according to the failure policy of its definition, empty when it is not failed.
+// definition is nil when the subscription is not in the state, health is nil
+// when the fields not exposed by the orion client have not been read.
+// Without a policy a subscription is failed when it has a last failure,
+// or a last success code of 300 or more, because @telefonica 404 MEANS SUCCESS
+func subscriptionFailure(
+	subscription *model.Subscription,
+	definition *entities.SubscriptionDefinition,
+	health *entities.SubscriptionHealth,
+	now time.Time,
+) string {
+	var desired *model.Subscription
+	var policy entities.FailurePolicy
+	if definition != nil {
+		desired = definition.Subscription
+		if definition.FailurePolicy != nil {
+			policy = *definition.FailurePolicy
+		}
+	}
+
+	notification := subscription.Notification
+	if notification == nil || isSubscriptionInactiveOnPurpose(subscription, desired) {
+		return ""
+	}
+
+	if notification.LastFailure != nil && isFailureRelevant(notification, policy, health, now) {
+		evidence := fmt.Sprintf("last failure at %s", notification.LastFailure.Format(time.RFC3339))
+		if health != nil && health.FailsCounter != nil {
+			evidence += fmt.Sprintf(", %d consecutive failures", *health.FailsCounter)
+		}
+		if health != nil && health.LastFailureReason != "" {
+			evidence += fmt.Sprintf(", reason: %s", health.LastFailureReason)
+		}
+		return evidence
+	}
+
+	if notification.LastSuccessCode != nil && !isSuccessCode(*notification.LastSuccessCode, policy) {
+		return fmt.Sprintf("last success code %d", *notification.LastSuccessCode)
+	}
+
+	return ""
+}
+
+// isSubscriptionFailed reports if the subscription must be healed
+func isSubscriptionFailed(
+	subscription *model.Subscription,
+	definition *entities.SubscriptionDefinition,
+	health *entities.SubscriptionHealth,
+) bool {
+	return subscriptionFailure(subscription, definition, health, time.Now()) != ""
+}
+
+// isFailureRelevant applies the failure policy to the last failure of the subscription,
+// the fails counter is checked only when the context broker reports it
+func isFailureRelevant(
+	notification *model.SubscriptionNotification,
+	policy entities.FailurePolicy,
+	health *entities.SubscriptionHealth,
+	now time.Time,
+) bool {
+	lastFailure := *notification.LastFailure
+	if policy.MinFailureAge != nil && now.Sub(lastFailure) < policy.MinFailureAge.Duration {
+		return false
+	}
+	if policy.FailureNewerThanSuccess && notification.LastSuccess != nil && !lastFailure.After(*notification.LastSuccess) {
+		return false
+	}
+	if health != nil && health.FailsCounter != nil && *health.FailsCounter < policy.MinFailsCounter {
+		return false
+	}
+	return true
+}
+
+func isSuccessCode(code uint, policy entities.FailurePolicy) bool {
+	if len(policy.SuccessCodes) == 0 {
+		return code < 300
+	}
+	for _, successCode := range policy.SuccessCodes {
+		if code == successCode {
+			return true
+		}
+	}
+	return false
+}
+
+func validateFailurePolicy(policy *entities.FailurePolicy) error {
+	if policy == nil {
+		return nil
+	}
+	if policy.MinFailureAge != nil && policy.MinFailureAge.Duration < 0 {
+		return errors.Errorf("min_failure_age cannot be negative, got %s", policy.MinFailureAge)
+	}
+	for _, code := range policy.SuccessCodes {
+		if code < 100 || code > 599 {
+			return errors.Errorf("invalid success code %d", code)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package usecases
+
+import (
+	"testing"
+	"time"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+)
+
+func TestSubscriptionFailureHealStrategy(t *testing.T) {
+	now := time.Date(2040, 1, 1, 12, 0, 0, 0, time.UTC)
+	recovered := `{"notification": {"http": {"url": "http://consumer"},
+		"lastFailure": "2040-01-01T10:00:00.000Z", "lastSuccess": "2040-01-01T11:00:00.000Z", "lastSuccessCode": 200}}`
+	failing := `{"notification": {"http": {"url": "http://consumer"},
+		"lastFailure": "2040-01-01T11:00:00.000Z", "lastSuccess": "2040-01-01T10:00:00.000Z", "lastSuccessCode": 200}}`
+
+	tests := []struct {
+		name                    string
+		subscription            string
+		healStrategy            string
+		failureNewerThanSuccess bool
+		failed                  bool
+	}{
+		{name: "reactivate recovered", subscription: recovered, healStrategy: entities.HealStrategyReactivate, failureNewerThanSuccess: true},
+		{name: "reactivate failing", subscription: failing, healStrategy: entities.HealStrategyReactivate, failureNewerThanSuccess: true, failed: true},
+		{name: "default strategy recovered", subscription: recovered, failed: true},
+		{name: "recreate recovered", subscription: recovered, healStrategy: entities.HealStrategyRecreate, failed: true},
+		{name: "recreate failing", subscription: failing, healStrategy: entities.HealStrategyRecreate, failed: true},
+		{name: "recreate recovered newer than success", subscription: recovered, healStrategy: entities.HealStrategyRecreate, failureNewerThanSuccess: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			definition := &entities.SubscriptionDefinition{
+				Subscription:  mustSubscription(t, `{"notification": {"http": {"url": "http://consumer"}}}`),
+				FailurePolicy: &entities.FailurePolicy{FailureNewerThanSuccess: test.failureNewerThanSuccess},
+				HealStrategy:  test.healStrategy,
+			}
+			evidence := subscriptionFailure(mustSubscription(t, test.subscription), definition, nil, now)
+			if failed := evidence != ""; failed != test.failed {
+				t.Fatalf("expected failed %v, got evidence %q", test.failed, evidence)
+			}
+		})
+	}
+}
+
+func TestValidateHealStrategy(t *testing.T) {
+	tests := []struct {
+		name         string
+		healStrategy string
+		policy       *entities.FailurePolicy
+		valid        bool
+	}{
+		{name: "default strategy", valid: true},
+		{name: "recreate", healStrategy: entities.HealStrategyRecreate, valid: true},
+		{name: "reactivate without policy", healStrategy: entities.HealStrategyReactivate},
+		{
+			name:         "reactivate with failures followed by a success",
+			healStrategy: entities.HealStrategyReactivate,
+			policy:       &entities.FailurePolicy{FailureNewerThanSuccess: false},
+		},
+		{
+			name:         "reactivate with failures newer than success",
+			healStrategy: entities.HealStrategyReactivate,
+			policy:       &entities.FailurePolicy{FailureNewerThanSuccess: true},
+			valid:        true,
+		},
+		{name: "unknown strategy", healStrategy: "restart"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateHealStrategy(test.healStrategy, test.policy)
+			if valid := err == nil; valid != test.valid {
+				t.Fatalf("expected valid %v, got error %v", test.valid, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package usecases
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/phoops/ngsiv2/client"
+	"github.com/phoops/ngsiv2/model"
+	"go.uber.org/zap"
+)
+
+// fakeBroker is an in memory context broker serving the subscriptions api
+// used by bellatrix, a single fiware-service/service path, it records
+// the bodies of the create requests
+type fakeBroker struct {
+	mu            sync.Mutex
+	server        *httptest.Server
+	subscriptions []json.RawMessage
+	nextID        int
+	createBodies  [][]byte
+	pageRequests  int
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	t.Helper()
+	broker := &fakeBroker{}
+	broker.server = httptest.NewServer(http.HandlerFunc(broker.serve))
+	t.Cleanup(broker.server.Close)
+	return broker
+}
+
+// addSubscription stores a subscription as orion returns it
+func (b *fakeBroker) addSubscription(t *testing.T, sub *model.Subscription) {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	sub.Id = fmt.Sprintf("%024d", b.nextID)
+	content, err := json.Marshal(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.subscriptions = append(b.subscriptions, content)
+}
+
+func (b *fakeBroker) client(t *testing.T) *client.NgsiV2Client {
+	t.Helper()
+	orionClient, err := client.NewNgsiV2Client(client.SetUrl(b.server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return orionClient
+}
+
+func (b *fakeBroker) serve(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v2":
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"subscriptions_url": "/v2/subscriptions"}`))
+	case r.Method == http.MethodGet && r.URL.Path == "/v2/subscriptions":
+		b.pageRequests++
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		end := offset + limit
+		if limit == 0 || end > len(b.subscriptions) {
+			end = len(b.subscriptions)
+		}
+		page := []json.RawMessage{}
+		if offset < len(b.subscriptions) {
+			page = b.subscriptions[offset:end]
+		}
+		if strings.Contains(r.URL.Query().Get("options"), "count") {
+			w.Header().Set("Fiware-Total-Count", strconv.Itoa(len(b.subscriptions)))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(page)
+	case r.Method == http.MethodPost && r.URL.Path == "/v2/subscriptions":
+		body, _ := ioutil.ReadAll(r.Body)
+		b.createBodies = append(b.createBodies, body)
+		var sub map[string]interface{}
+		_ = json.Unmarshal(body, &sub)
+		if !isRequestableStatus(sub["status"]) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b.nextID++
+		id := fmt.Sprintf("%024d", b.nextID)
+		sub["id"] = id
+		content, _ := json.Marshal(sub)
+		b.subscriptions = append(b.subscriptions, content)
+		w.Header().Set("Location", "/v2/subscriptions/"+id)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, "/v2/subscriptions/"):
+		id := strings.TrimPrefix(r.URL.Path, "/v2/subscriptions/")
+		body, _ := ioutil.ReadAll(r.Body)
+		var changes map[string]interface{}
+		_ = json.Unmarshal(body, &changes)
+		if !isRequestableStatus(changes["status"]) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for i, content := range b.subscriptions {
+			var sub map[string]interface{}
+			_ = json.Unmarshal(content, &sub)
+			if sub["id"] == id {
+				for field, value := range changes {
+					sub[field] = value
+				}
+				b.subscriptions[i], _ = json.Marshal(sub)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/v2/subscriptions/"):
+		id := strings.TrimPrefix(r.URL.Path, "/v2/subscriptions/")
+		for i, content := range b.subscriptions {
+			var sub model.Subscription
+			_ = json.Unmarshal(content, &sub)
+			if sub.Id == id {
+				b.subscriptions = append(b.subscriptions[:i], b.subscriptions[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// isRequestableStatus reports if orion accepts the status in a request,
+// the statuses like failed or expired are set only by orion
+func isRequestableStatus(status interface{}) bool {
+	switch status {
+	case nil, "active", "inactive", "oneshot":
+		return true
+	}
+	return false
+}
+
+// subscription returns the subscription stored with the given description
+func (b *fakeBroker) subscription(t *testing.T, description string) *model.Subscription {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, content := range b.subscriptions {
+		var sub model.Subscription
+		if err := json.Unmarshal(content, &sub); err != nil {
+			t.Fatal(err)
+		}
+		if sub.Description == description {
+			return &sub
+		}
+	}
+	return nil
+}
+
+func testRetryPolicy() RetryPolicy {
+	return NewRetryPolicy(1, 0, 0)
+}
+
+func testLogger() *zap.Logger {
+	return zap.NewNop()
+}
+
+// brokerUsecases are the usecases sending requests to a fake broker
+type brokerUsecases struct {
+	getAvailableSubscriptions *GetAvailableSubscriptions
+	createSubscription        *CreateSubscription
+	updateSubscription        *UpdateSubscription
+	deleteSubscription        *DeleteSubscription
+}
+
+func newBrokerUsecases(t *testing.T, broker *fakeBroker) brokerUsecases {
+	t.Helper()
+	orionClient := broker.client(t)
+	getAvailableSubscriptions := NewGetAvailableSubscriptions(orionClient, 0, 0, testRetryPolicy(), testLogger())
+	return brokerUsecases{
+		getAvailableSubscriptions: getAvailableSubscriptions,
+		createSubscription:        NewCreateSubscription(orionClient, getAvailableSubscriptions, testRetryPolicy(), testLogger()),
+		updateSubscription:        NewUpdateSubscription(orionClient, testRetryPolicy(), testLogger()),
+		deleteSubscription:        NewDeleteSubscription(orionClient, testRetryPolicy(), testLogger()),
+	}
+}
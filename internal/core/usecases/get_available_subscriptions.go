@@ -1,41 +1,177 @@
 package usecases
 
 import (
+	"context"
+
 	"github.com/phoops/ngsiv2/client"
 	"github.com/phoops/ngsiv2/model"
 	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultSubscriptionsPageSize is the number of subscriptions retrieved
+	// with a single request, orion default is 20 and the maximum is 1000
+	DefaultSubscriptionsPageSize = 100
+	// DefaultMaxSubscriptionsPerScope is the safety cap on the number
+	// of subscriptions retrieved for a single fiware-service/service path
+	DefaultMaxSubscriptionsPerScope = 10000
 )
 
 type GetAvailableSubscriptions struct {
 	orionClient *client.NgsiV2Client
+	pagination  subscriptionsPagination
+	retryPolicy RetryPolicy
+	logger      *zap.Logger
 }
 
+// Execute retrieves all the subscriptions of the fiware-service/service path,
+// following the pagination using the total count returned by orion
 func (u *GetAvailableSubscriptions) Execute(
+	ctx context.Context,
 	fiwareService string,
 	servicePath string,
 ) ([]*model.Subscription, error) {
-	response, err := u.orionClient.RetrieveSubscriptions(
-		client.RetrieveSubscriptionsSetFiwareServicePath(servicePath),
-		client.RetrieveSubscriptionsSetFiwareService(fiwareService),
-	)
+	var subscriptions []*model.Subscription
+	var page []*model.Subscription
 
+	err := u.pagination.walk(
+		func(offset int) ([]string, int, error) {
+			var response *client.SubscriptionsResponse
+			err := u.retryPolicy.retry(
+				ctx,
+				u.logger,
+				"retrieve subscriptions",
+				func() error {
+					var err error
+					response, err = u.orionClient.RetrieveSubscriptions(
+						client.RetrieveSubscriptionsSetFiwareServicePath(servicePath),
+						client.RetrieveSubscriptionsSetFiwareService(fiwareService),
+						client.RetrieveSubscriptionsSetLimit(u.pagination.pageSize),
+						client.RetrieveSubscriptionsSetOffset(offset),
+						client.RetrieveSubscriptionsSetOptions("count"),
+					)
+					return err
+				},
+				nil,
+			)
+			if err != nil {
+				return nil, 0, errors.Wrap(err, "could not retrieve subscriptions from context broker")
+			}
+
+			page = response.Subscriptions
+			ids := make([]string, len(page))
+			for i, sub := range page {
+				ids[i] = sub.Id
+			}
+			return ids, response.Count, nil
+		},
+		func(index int) {
+			subscriptions = append(subscriptions, page[index])
+		},
+	)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not retrieve subscriptions from context broker")
+		return nil, err
 	}
 
-	if len(response.Subscriptions) == 0 {
+	if len(subscriptions) == 0 {
 		return nil, nil
 	}
 
-	return response.Subscriptions, nil
+	return subscriptions, nil
+}
+
+// subscriptionsPagination walks the pages of the subscriptions of a fiware-service/service path,
+// shared by every usecase listing the subscriptions, so they stop at the same page
+type subscriptionsPagination struct {
+	pageSize         int
+	maxSubscriptions int
+}
+
+// newSubscriptionsPagination returns a pagination, 0 selects the defaults
+func newSubscriptionsPagination(pageSize int, maxSubscriptions int) subscriptionsPagination {
+	if pageSize <= 0 {
+		pageSize = DefaultSubscriptionsPageSize
+	}
+	if maxSubscriptions <= 0 {
+		maxSubscriptions = DefaultMaxSubscriptionsPerScope
+	}
+	return subscriptionsPagination{
+		pageSize:         pageSize,
+		maxSubscriptions: maxSubscriptions,
+	}
+}
+
+// walk reads the pages until the last one. readPage returns the ids of the subscriptions
+// in the page at offset and the total count reported by orion, 0 when not reported;
+// keep is called with the index in the page of every subscription not seen before
+func (p subscriptionsPagination) walk(
+	readPage func(offset int) ([]string, int, error),
+	keep func(index int),
+) error {
+	seen := make(map[string]bool)
+
+	for offset := 0; ; offset += p.pageSize {
+		ids, count, err := readPage(offset)
+		if err != nil {
+			return err
+		}
+
+		if count > p.maxSubscriptions {
+			return errors.Errorf(
+				"context broker reports %d subscriptions, more than the maximum allowed of %d",
+				count,
+				p.maxSubscriptions,
+			)
+		}
+
+		// subscriptions created or deleted while we are paginating
+		// could shift the pages, so we skip the ones already seen
+		for i, id := range ids {
+			if !seen[id] {
+				seen[id] = true
+				keep(i)
+			}
+		}
+
+		if len(seen) > p.maxSubscriptions {
+			return errors.Errorf(
+				"more than the maximum allowed of %d subscriptions retrieved from context broker",
+				p.maxSubscriptions,
+			)
+		}
+
+		if isLastSubscriptionsPage(len(ids), count, offset, p.pageSize) {
+			return nil
+		}
+	}
+}
+
+// isLastSubscriptionsPage checks the total count returned by orion, when the count
+// is not available a page shorter than the requested limit is the last one
+func isLastSubscriptionsPage(pageLength int, count int, offset int, pageSize int) bool {
+	if pageLength == 0 {
+		return true
+	}
+	if count > 0 {
+		return offset+pageLength >= count
+	}
+	return pageLength < pageSize
 }
 
 // NewGetAvailableSubscriptions returns a new configured GetAvailableSubscriptions
-// usecases
+// usecases, every page request is retried following the retry policy
 func NewGetAvailableSubscriptions(
 	orionClient *client.NgsiV2Client,
+	pageSize int,
+	maxSubscriptions int,
+	retryPolicy RetryPolicy,
+	logger *zap.Logger,
 ) *GetAvailableSubscriptions {
 	return &GetAvailableSubscriptions{
 		orionClient: orionClient,
+		pagination:  newSubscriptionsPagination(pageSize, maxSubscriptions),
+		retryPolicy: retryPolicy,
+		logger:      logger,
 	}
 }
@@ -0,0 +1,92 @@
+package usecases
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/phoops/ngsiv2/model"
+)
+
+func subscriptionForScope(i int) *model.Subscription {
+	return &model.Subscription{
+		Description: fmt.Sprintf("%ssubscription-%03d", BellatrixManagedSubscriptionsPrefix, i),
+		Subject: &model.SubscriptionSubject{
+			Entities: []*model.SubscriptionSubjectEntity{{IdPattern: ".*", Type: fmt.Sprintf("Type%d", i)}},
+		},
+		Notification: &model.SubscriptionNotification{
+			Http: &model.SubscriptionNotificationHttp{Url: "http://consumer/notify"},
+		},
+	}
+}
+
+func TestGetAvailableSubscriptionsLargeScope(t *testing.T) {
+	const subscriptionsCount = 500
+	const pageSize = 70
+
+	broker := newFakeBroker(t)
+	var definitions []*entities.SubscriptionDefinition
+	for i := 0; i < subscriptionsCount; i++ {
+		broker.addSubscription(t, subscriptionForScope(i))
+		definitions = append(definitions, &entities.SubscriptionDefinition{Subscription: subscriptionForScope(i)})
+	}
+
+	getAvailableSubscriptions := NewGetAvailableSubscriptions(
+		broker.client(t),
+		pageSize,
+		DefaultMaxSubscriptionsPerScope,
+		testRetryPolicy(),
+		testLogger(),
+	)
+
+	subscriptions, err := getAvailableSubscriptions.Execute(context.Background(), "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(subscriptions) != subscriptionsCount {
+		t.Fatalf("expected %d subscriptions, got %d", subscriptionsCount, len(subscriptions))
+	}
+	seen := make(map[string]bool)
+	for _, sub := range subscriptions {
+		if seen[sub.Id] {
+			t.Fatalf("subscription %s retrieved twice", sub.Id)
+		}
+		seen[sub.Id] = true
+	}
+	expectedPages := (subscriptionsCount + pageSize - 1) / pageSize
+	if broker.pageRequests != expectedPages {
+		t.Fatalf("expected %d page requests, got %d", expectedPages, broker.pageRequests)
+	}
+
+	patch := getBellatrixSubscriptionsDiff(definitions, subscriptions, "")
+	if !patch.IsEmpty() {
+		t.Fatalf(
+			"expected an empty patch, got %d to add, %d to update, %d to delete, %d duplicates",
+			len(patch.SubscriptionsToAdd),
+			len(patch.SubscriptionsToUpdate),
+			len(patch.SubscriptionsToDelete),
+			len(patch.DuplicatesToDelete),
+		)
+	}
+}
+
+func TestGetAvailableSubscriptionsOverMaxSubscriptions(t *testing.T) {
+	broker := newFakeBroker(t)
+	for i := 0; i < 500; i++ {
+		broker.addSubscription(t, subscriptionForScope(i))
+	}
+
+	getAvailableSubscriptions := NewGetAvailableSubscriptions(
+		broker.client(t),
+		100,
+		499,
+		testRetryPolicy(),
+		testLogger(),
+	)
+
+	_, err := getAvailableSubscriptions.Execute(context.Background(), "", "")
+	if err == nil {
+		t.Fatal("expected an error over the maximum of subscriptions")
+	}
+}
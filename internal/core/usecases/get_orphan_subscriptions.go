@@ -0,0 +1,121 @@
+package usecases
+
+import (
+	"context"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// GetOrphanSubscriptions finds the subscriptions managed by bellatrix
+// that are no longer claimed by the requested state, inside a set of candidate scopes.
+// Bellatrix cannot see the scopes that are not in the state file anymore,
+// so the candidate scopes must be provided by the user.
+type GetOrphanSubscriptions struct {
+	getAvailableSubscriptions *GetAvailableSubscriptions
+	logger                    *zap.Logger
+	instancePrefix            string
+	destroyGuard              DestroyGuard
+}
+
+// NewGetOrphanSubscriptions returns a new configured GetOrphanSubscriptions usecase,
+// the delete patches are checked by the destroy guard like the ones of a sync
+func NewGetOrphanSubscriptions(
+	getAvailableSubscriptions *GetAvailableSubscriptions,
+	logger *zap.Logger,
+	instancePrefix string,
+	destroyGuard DestroyGuard,
+) *GetOrphanSubscriptions {
+	return &GetOrphanSubscriptions{
+		getAvailableSubscriptions: getAvailableSubscriptions,
+		logger:                    logger,
+		instancePrefix:            instancePrefix,
+		destroyGuard:              destroyGuard,
+	}
+}
+
+// Execute returns a delete patch for every candidate scope containing orphan subscriptions,
+// the patches are returned with the error when the destroy guard refuses them
+func (u *GetOrphanSubscriptions) Execute(
+	ctx context.Context,
+	candidateScopes []entities.SubscriptionsScope,
+	requestedSubscriptions []entities.SubscriptionRequest,
+) ([]*entities.SubscriptionsPatch, error) {
+	claimedDescriptions := make(map[entities.SubscriptionsScope]map[string]bool)
+	for _, request := range requestedSubscriptions {
+		scope := entities.SubscriptionsScope{
+			FiwareService: request.FiwareService,
+			ServicePath:   request.ServicePath,
+		}
+		if claimedDescriptions[scope] == nil {
+			claimedDescriptions[scope] = make(map[string]bool)
+		}
+		for _, sub := range request.Subscriptions {
+			name, _ := managedSubscriptionName(sub.Description, u.instancePrefix)
+			claimedDescriptions[scope][name] = true
+		}
+	}
+
+	var orphanPatches []*entities.SubscriptionsPatch
+	managedCounts := make(map[entities.SubscriptionsScope]int)
+	for _, scope := range candidateScopes {
+		subscriptionsInOrion, err := u.getAvailableSubscriptions.Execute(
+			ctx,
+			scope.FiwareService,
+			scope.ServicePath,
+		)
+
+		if err != nil {
+			return nil, errors.Wrapf(
+				err,
+				"could not get subscriptions on context broker for servicePath %s, and fiwareService %s, during orphans search",
+				scope.ServicePath,
+				scope.FiwareService,
+			)
+		}
+
+		patch := &entities.SubscriptionsPatch{
+			FiwareService: scope.FiwareService,
+			ServicePath:   scope.ServicePath,
+		}
+		managedSubscriptions := getSubscriptionsManagedByBellatrix(subscriptionsInOrion, u.instancePrefix)
+		managedCounts[scope] = len(managedSubscriptions)
+		for _, sub := range managedSubscriptions {
+			name, _ := managedSubscriptionName(sub.Description, u.instancePrefix)
+			if claimedDescriptions[scope][name] {
+				continue
+			}
+			// protected subscriptions are never deleted as orphans, the protection
+			// must be removed syncing a state that claims them first
+			if isSubscriptionProtected(sub, u.instancePrefix) {
+				u.logger.Warn(
+					"Orphan subscription is protected by prevent_destroy, it will not be deleted",
+					zap.String("subscription_id", sub.Id),
+					zap.String("subscription_description", sub.Description),
+					zap.String("fiware_service", scope.FiwareService),
+					zap.String("fiware_service_path", scope.ServicePath),
+				)
+				continue
+			}
+			patch.SubscriptionsToDelete = append(patch.SubscriptionsToDelete, sub)
+		}
+
+		u.logger.Debug(
+			"Orphan subscriptions",
+			zap.Any("orphan_subscriptions", patch.SubscriptionsToDelete),
+			zap.String("fiware_service", scope.FiwareService),
+			zap.String("fiware_service_path", scope.ServicePath),
+		)
+
+		if !patch.IsEmpty() {
+			orphanPatches = append(orphanPatches, patch)
+		}
+	}
+
+	if err := u.destroyGuard.check(orphanPatches, managedCounts, nil, u.instancePrefix); err != nil {
+		return orphanPatches, err
+	}
+
+	return orphanPatches, nil
+}
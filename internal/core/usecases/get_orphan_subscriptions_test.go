@@ -0,0 +1,49 @@
+package usecases
+
+import (
+	"context"
+	"testing"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/pkg/errors"
+)
+
+func TestGetOrphanSubscriptionsDestroyGuard(t *testing.T) {
+	scope := entities.SubscriptionsScope{FiwareService: "Wolfsburg", ServicePath: "/WasteMGT"}
+
+	tests := []struct {
+		name         string
+		destroyGuard DestroyGuard
+		refused      bool
+	}{
+		{name: "default guard", destroyGuard: DestroyGuard{MaxDeletionsPercent: DefaultMaxDeletionsPercent}, refused: true},
+		{name: "allow destroy", destroyGuard: DestroyGuard{AllowDestroy: true}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			broker := newFakeBroker(t)
+			for i := 0; i < 3; i++ {
+				broker.addSubscription(t, subscriptionForScope(i))
+			}
+			scopeUsecases := newBrokerUsecases(t, broker)
+
+			patches, err := NewGetOrphanSubscriptions(
+				scopeUsecases.getAvailableSubscriptions,
+				testLogger(),
+				"",
+				test.destroyGuard,
+			).Execute(context.Background(), []entities.SubscriptionsScope{scope}, nil)
+
+			if len(patches) != 1 || len(patches[0].SubscriptionsToDelete) != 3 {
+				t.Fatalf("expected the 3 orphans of the scope, got %+v", patches)
+			}
+			if refused := errors.Cause(err) == ErrDestructiveChanges; refused != test.refused {
+				t.Fatalf("expected refused %v, got error %v", test.refused, err)
+			}
+			if !test.refused && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
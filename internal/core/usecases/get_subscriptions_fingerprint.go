@@ -0,0 +1,102 @@
+package usecases
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/pkg/errors"
+)
+
+// GetSubscriptionsFingerprint computes a fingerprint of the subscriptions managed
+// by bellatrix on the context broker, so we can check if they have changed
+// between two points in time.
+// Only the fields that can be requested in the state file are considered,
+// the runtime fields like timesSent or lastNotification change on every notification.
+type GetSubscriptionsFingerprint struct {
+	getAvailableSubscriptions *GetAvailableSubscriptions
+	instancePrefix            string
+}
+
+func NewGetSubscriptionsFingerprint(
+	getAvailableSubscriptions *GetAvailableSubscriptions,
+	instancePrefix string,
+) *GetSubscriptionsFingerprint {
+	return &GetSubscriptionsFingerprint{getAvailableSubscriptions: getAvailableSubscriptions, instancePrefix: instancePrefix}
+}
+
+func (u *GetSubscriptionsFingerprint) Execute(
+	ctx context.Context,
+	scopes []entities.SubscriptionsScope,
+) (string, error) {
+	hash := sha256.New()
+
+	for _, scope := range scopes {
+		subscriptionsInOrion, err := u.getAvailableSubscriptions.Execute(
+			ctx,
+			scope.FiwareService,
+			scope.ServicePath,
+		)
+
+		if err != nil {
+			return "", errors.Wrapf(
+				err,
+				"could not get subscriptions on context broker for servicePath %s, and fiwareService %s, during fingerprint computing",
+				scope.ServicePath,
+				scope.FiwareService,
+			)
+		}
+
+		orionSubsManagedByBellatrix := getSubscriptionsManagedByBellatrix(subscriptionsInOrion, u.instancePrefix)
+		sort.Slice(orionSubsManagedByBellatrix, func(i, j int) bool {
+			return orionSubsManagedByBellatrix[i].Id < orionSubsManagedByBellatrix[j].Id
+		})
+
+		fmt.Fprintf(hash, "scope %q %q\n", scope.FiwareService, scope.ServicePath)
+		for _, sub := range orionSubsManagedByBellatrix {
+			fields := flattenSubscription(comparableSubscription(sub))
+			fieldNames := make([]string, 0, len(fields))
+			for field := range fields {
+				fieldNames = append(fieldNames, field)
+			}
+			sort.Strings(fieldNames)
+
+			fmt.Fprintf(hash, "subscription %q\n", sub.Id)
+			for _, field := range fieldNames {
+				fmt.Fprintf(hash, "%q=%q\n", field, fields[field])
+			}
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// getRequestsScopes returns the distinct scopes of the subscription requests, sorted
+func getRequestsScopes(requests []entities.SubscriptionRequest) []entities.SubscriptionsScope {
+	var scopes []entities.SubscriptionsScope
+	seen := make(map[entities.SubscriptionsScope]bool)
+	for _, request := range requests {
+		scope := requestScope(request)
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	sort.Slice(scopes, func(i, j int) bool {
+		if scopes[i].FiwareService != scopes[j].FiwareService {
+			return scopes[i].FiwareService < scopes[j].FiwareService
+		}
+		return scopes[i].ServicePath < scopes[j].ServicePath
+	})
+	return scopes
+}
+
+func requestScope(request entities.SubscriptionRequest) entities.SubscriptionsScope {
+	return entities.SubscriptionsScope{
+		FiwareService: request.FiwareService,
+		ServicePath:   request.ServicePath,
+	}
+}
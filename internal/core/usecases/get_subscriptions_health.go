@@ -0,0 +1,97 @@
+package usecases
+
+import (
+	"context"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// SubscriptionsHealthReader reads the notification fields of the subscriptions
+// reported by the context broker and not exposed by the orion client, like failsCounter
+type SubscriptionsHealthReader interface {
+	// ReadSubscriptionsHealthPage reads a page of the subscriptions of the fiware-service/service path,
+	// it returns the total count reported by the context broker, 0 when not reported
+	ReadSubscriptionsHealthPage(
+		ctx context.Context,
+		fiwareService string,
+		servicePath string,
+		limit int,
+		offset int,
+	) ([]*entities.SubscriptionHealth, int, error)
+}
+
+type GetSubscriptionsHealth struct {
+	healthReader SubscriptionsHealthReader
+	pagination   subscriptionsPagination
+	retryPolicy  RetryPolicy
+	logger       *zap.Logger
+}
+
+// NewGetSubscriptionsHealth returns a new configured GetSubscriptionsHealth usecase,
+// the pages are read like the ones of GetAvailableSubscriptions, 0 selects the defaults
+func NewGetSubscriptionsHealth(
+	healthReader SubscriptionsHealthReader,
+	pageSize int,
+	maxSubscriptions int,
+	retryPolicy RetryPolicy,
+	logger *zap.Logger,
+) *GetSubscriptionsHealth {
+	return &GetSubscriptionsHealth{
+		healthReader: healthReader,
+		pagination:   newSubscriptionsPagination(pageSize, maxSubscriptions),
+		retryPolicy:  retryPolicy,
+		logger:       logger,
+	}
+}
+
+// Execute returns the health of the subscriptions of the fiware-service/service path by id
+func (u *GetSubscriptionsHealth) Execute(
+	ctx context.Context,
+	fiwareService string,
+	servicePath string,
+) (map[string]*entities.SubscriptionHealth, error) {
+	healthByID := make(map[string]*entities.SubscriptionHealth)
+	var page []*entities.SubscriptionHealth
+
+	err := u.pagination.walk(
+		func(offset int) ([]string, int, error) {
+			var count int
+			err := u.retryPolicy.retry(
+				ctx,
+				u.logger,
+				"read subscriptions health",
+				func() error {
+					var err error
+					page, count, err = u.healthReader.ReadSubscriptionsHealthPage(
+						ctx,
+						fiwareService,
+						servicePath,
+						u.pagination.pageSize,
+						offset,
+					)
+					return err
+				},
+				nil,
+			)
+			if err != nil {
+				return nil, 0, errors.Wrap(err, "could not read the subscriptions health from context broker")
+			}
+
+			ids := make([]string, len(page))
+			for i, health := range page {
+				ids[i] = health.ID
+			}
+			return ids, count, nil
+		},
+		func(index int) {
+			healthByID[page[index].ID] = page[index]
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return healthByID, nil
+}
@@ -1,6 +1,9 @@
 package usecases
 
 import (
+	"context"
+	"fmt"
+	"sort"
 	"strings"
 
 	"go.uber.org/zap"
@@ -15,70 +18,177 @@ import (
 // when we crud the subscriptions
 const BellatrixManagedSubscriptionsPrefix = "BELLATRIX_MANAGED_"
 
+// BellatrixProtectedSubscriptionsPrefix replaces BellatrixManagedSubscriptionsPrefix
+// in the description of the subscriptions requested with prevent_destroy,
+// so the protection is known even when the subscription is removed from the state
+const BellatrixProtectedSubscriptionsPrefix = "BELLATRIX_PROTECTED_"
+
+// ErrProtectedSubscription is returned when the patches delete or replace
+// a subscription protected by prevent_destroy
+var ErrProtectedSubscription = errors.New("protected subscriptions cannot be deleted or replaced")
+
 type GetSubscriptionsPatches struct {
 	getAvailableSubscriptions *GetAvailableSubscriptions
 	logger                    *zap.Logger
 	instancePrefix            string
+	parallelism               int
+	destroyGuard              DestroyGuard
+	selection                 Selection
 }
 
 func NewGetSubscriptionsPatches(
 	getAvailableSubscriptions *GetAvailableSubscriptions,
 	logger *zap.Logger,
 	instancePrefix string,
+	parallelism int,
+	destroyGuard DestroyGuard,
+	selection Selection,
 ) *GetSubscriptionsPatches {
-	return &GetSubscriptionsPatches{getAvailableSubscriptions: getAvailableSubscriptions, logger: logger, instancePrefix: instancePrefix}
+	return &GetSubscriptionsPatches{
+		getAvailableSubscriptions: getAvailableSubscriptions,
+		logger:                    logger,
+		instancePrefix:            instancePrefix,
+		parallelism:               parallelism,
+		destroyGuard:              destroyGuard,
+		selection:                 selection,
+	}
 }
 
 func (u *GetSubscriptionsPatches) Execute(
+	ctx context.Context,
 	requestedSubscriptions []entities.SubscriptionRequest,
 ) ([]*entities.SubscriptionsPatch, error) {
+	requestsPatches := make([]*entities.SubscriptionsPatch, len(requestedSubscriptions))
+	managedCounts := make([]int, len(requestedSubscriptions))
 
-	var subsPatches []*entities.SubscriptionsPatch
 	// for each subscription request, we will check the managed bellatrix subscriptions
-	// on the context broker, for each service/servicepath specified in each request
-	for _, request := range requestedSubscriptions {
-		subscriptionsInOrion, err := u.getAvailableSubscriptions.Execute(
-			request.FiwareService,
+	// on the context broker, for each service/servicepath specified in each request,
+	// the scopes are checked concurrently
+	groups := groupByScope(len(requestedSubscriptions), func(i int) entities.SubscriptionsScope {
+		return requestScope(requestedSubscriptions[i])
+	})
+	tasks := make([]scopeTask, len(groups))
+	for g, group := range groups {
+		group := group
+		tasks[g] = func(logger *zap.Logger) error {
+			for _, i := range group {
+				patch, managedCount, err := u.getRequestPatch(ctx, requestedSubscriptions[i], logger)
+				if err != nil {
+					return err
+				}
+				requestsPatches[i] = patch
+				managedCounts[i] = managedCount
+			}
+			return nil
+		}
+	}
+
+	err := firstError(runScopeTasks(ctx, tasks, u.parallelism, u.logger, true))
+	if err != nil {
+		return nil, err
+	}
+
+	var subsPatches []*entities.SubscriptionsPatch
+	scopesManagedCounts := make(map[entities.SubscriptionsScope]int)
+	// the scopes requested without subscriptions, like the managed scopes removed
+	// from the state, have their subscriptions deleted on purpose
+	removedScopes := make(map[entities.SubscriptionsScope]bool)
+	for i, patch := range requestsPatches {
+		scope := requestScope(requestedSubscriptions[i])
+		scopesManagedCounts[scope] = managedCounts[i]
+		if removed, seen := removedScopes[scope]; !seen || removed {
+			removedScopes[scope] = len(requestedSubscriptions[i].Subscriptions) == 0
+		}
+		// the patches with only skipped changes or flagged subscriptions are kept, so they can be shown
+		if !patch.IsEmpty() || len(patch.Skipped) > 0 || len(patch.InactiveSubscriptions) > 0 {
+			subsPatches = append(subsPatches, patch)
+		}
+	}
+
+	// the deletions are checked on the whole run, before any change is applied
+	err = u.destroyGuard.check(subsPatches, scopesManagedCounts, removedScopes, u.instancePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return subsPatches, nil
+}
+
+func (u *GetSubscriptionsPatches) getRequestPatch(
+	ctx context.Context,
+	request entities.SubscriptionRequest,
+	logger *zap.Logger,
+) (*entities.SubscriptionsPatch, int, error) {
+	subscriptionsInOrion, err := u.getAvailableSubscriptions.Execute(
+		ctx,
+		request.FiwareService,
+		request.ServicePath,
+	)
+
+	orionSubsManagedByBellatrix := getSubscriptionsManagedByBellatrix(subscriptionsInOrion, u.instancePrefix)
+
+	if err != nil {
+		return nil, 0, errors.Wrapf(
+			err,
+			"could not get subscriptions on context broker for servicePath %s, and fiwareService %s",
 			request.ServicePath,
+			request.FiwareService,
 		)
+	}
 
-		orionSubsManagedByBellatrix := getSubscriptionsManagedByBellatrix(subscriptionsInOrion, u.instancePrefix)
+	// we will check the desired subscriptions passed as parameter
+	// against the subscriptions managed by bellatrix
+	// and we will apply the add/update/delete patches in order to match the
+	// desired state
+	patch := getBellatrixSubscriptionsDiff(
+		request.Subscriptions,
+		orionSubsManagedByBellatrix,
+		u.instancePrefix,
+	)
+	patch.FiwareService = request.FiwareService
+	patch.ServicePath = request.ServicePath
+	selectPatch(patch, u.selection, request.Subscriptions, u.instancePrefix)
 
-		if err != nil {
-			return nil, errors.Wrapf(
-				err,
-				"could not get subscriptions on context broker for servicePath %s, and fiwareService %s",
-				request.ServicePath,
-				request.FiwareService,
-			)
-		}
+	err = checkProtectedSubscriptions(patch, u.instancePrefix)
+	if err != nil {
+		return nil, 0, errors.Wrapf(
+			err,
+			"refused changes for servicePath %s, and fiwareService %s",
+			request.ServicePath,
+			request.FiwareService,
+		)
+	}
 
-		// we will check the desired subscriptions passed as parameter
-		// against the subscriptions managed by bellatrix
-		// and we will apply the add/delete patches in order to match the
-		// desired state
-		subscriptionsToAdd, subscriptionsToDelete := getBellatrixSubscriptionsDiff(
-			request.Subscriptions,
-			orionSubsManagedByBellatrix,
+	logger.Debug(
+		"Subscriptions diff",
+		zap.Any("subscriptions_to_delete", patch.SubscriptionsToDelete),
+		zap.Any("duplicates_to_delete", patch.DuplicatesToDelete),
+		zap.Any("subscriptions_to_update", patch.SubscriptionsToUpdate),
+		zap.Any("subscriptions_to_add", patch.SubscriptionsToAdd),
+		zap.Any("skipped", patch.Skipped),
+		zap.String("fiware_service", request.FiwareService),
+		zap.String("fiware_service_path", request.ServicePath),
+	)
+	for _, inactive := range patch.InactiveSubscriptions {
+		logger.Warn(
+			"Managed subscription is inactive but the state does not request it, set its status to reactivate it",
+			zap.String("subscription_id", inactive.Id),
+			zap.String("subscription_description", inactive.Description),
+			zap.String("fiware_service", request.FiwareService),
+			zap.String("fiware_service_path", request.ServicePath),
 		)
-		u.logger.Debug(
-			"Subscriptions diff",
-			zap.Any("subscriptions_to_delete", subscriptionsToDelete),
-			zap.Any("subscriptions_to_add", subscriptionsToAdd),
+	}
+	for _, duplicate := range patch.DuplicatesToDelete {
+		logger.Warn(
+			"Duplicated managed subscription found, it will be deleted",
+			zap.String("subscription_id", duplicate.Id),
+			zap.String("subscription_description", duplicate.Description),
 			zap.String("fiware_service", request.FiwareService),
 			zap.String("fiware_service_path", request.ServicePath),
 		)
-
-		if len(subscriptionsToAdd) != 0 || len(subscriptionsToDelete) != 0 {
-			subsPatches = append(subsPatches, &entities.SubscriptionsPatch{
-				ServicePath:           request.ServicePath,
-				FiwareService:         request.FiwareService,
-				SubscriptionsToAdd:    subscriptionsToAdd,
-				SubscriptionsToDelete: subscriptionsToDelete,
-			})
-		}
 	}
-	return subsPatches, nil
+
+	return patch, len(orionSubsManagedByBellatrix), nil
 }
 
 func getSubscriptionsManagedByBellatrix(
@@ -86,52 +196,179 @@ func getSubscriptionsManagedByBellatrix(
 	instancePrefix string,
 ) []*model.Subscription {
 	var managedSubscriptions []*model.Subscription
-	// full prefix is the join of instance prefix and bellatrix prefix
-	fullPrefix := instancePrefix + BellatrixManagedSubscriptionsPrefix
 	for _, sub := range subscriptions {
-		if strings.HasPrefix(sub.Description, fullPrefix) {
+		if _, managed := managedSubscriptionName(sub.Description, instancePrefix); managed {
 			managedSubscriptions = append(managedSubscriptions, sub)
 		}
 	}
 	return managedSubscriptions
 }
 
+// managedSubscriptionName returns the description of a subscription without
+// the bellatrix prefix, protected or not, as it is written in the state file,
+// and reports if the subscription is managed by bellatrix.
+// The full prefix is the join of instance prefix and bellatrix prefix.
+func managedSubscriptionName(description string, instancePrefix string) (string, bool) {
+	for _, prefix := range []string{
+		instancePrefix + BellatrixManagedSubscriptionsPrefix,
+		instancePrefix + BellatrixProtectedSubscriptionsPrefix,
+	} {
+		if strings.HasPrefix(description, prefix) {
+			return strings.TrimPrefix(description, prefix), true
+		}
+	}
+	return description, false
+}
+
+// isSubscriptionProtected reports if the subscription on the context broker
+// has been created or updated with prevent_destroy
+func isSubscriptionProtected(subscription *model.Subscription, instancePrefix string) bool {
+	return strings.HasPrefix(subscription.Description, instancePrefix+BellatrixProtectedSubscriptionsPrefix)
+}
+
+// checkProtectedSubscriptions refuses the patch if it deletes or replaces a protected
+// subscription, or updates it with changes other than the removal of the protection.
+// Duplicates of a protected subscription can be deleted, the subscription is kept.
+func checkProtectedSubscriptions(patch *entities.SubscriptionsPatch, instancePrefix string) error {
+	added := make(map[string]bool)
+	for _, sub := range patch.SubscriptionsToAdd {
+		name, _ := managedSubscriptionName(sub.Description, instancePrefix)
+		added[name] = true
+	}
+
+	var violations []string
+	for _, sub := range patch.SubscriptionsToDelete {
+		if !isSubscriptionProtected(sub, instancePrefix) {
+			continue
+		}
+		name, _ := managedSubscriptionName(sub.Description, instancePrefix)
+		if added[name] {
+			violations = append(violations, fmt.Sprintf("subscription %s would be replaced", name))
+		} else {
+			violations = append(violations, fmt.Sprintf("subscription %s would be deleted", name))
+		}
+	}
+	for _, update := range patch.SubscriptionsToUpdate {
+		if !isSubscriptionProtected(update.Current, instancePrefix) {
+			continue
+		}
+		for _, change := range update.Changes {
+			if change.Field != "description" {
+				name, _ := managedSubscriptionName(update.Current.Description, instancePrefix)
+				violations = append(violations, fmt.Sprintf(
+					"subscription %s would be updated, remove prevent_destroy with a separate change first",
+					name,
+				))
+				break
+			}
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	return errors.Wrap(ErrProtectedSubscription, strings.Join(violations, "; "))
+}
+
 // getBellatrixSubscriptionsDiff will check the differences between the subscriptions
 // in orion and the desired subscriptions state
 // the subscriptions involved in this comparison have the difference populated
 // with the bellatrix prefix
 // we assume this.
+// Subscriptions are matched by description without the bellatrix prefix, so adding
+// or removing the protection is an update, a matched subscription with a different
+// content is updated in place, unless the change cannot be expressed with a PATCH,
+// in that case it is deleted and recreated.
+// When orion contains more subscriptions with the same description, only one
+// is kept and the others are scheduled for deletion as duplicates.
+// The kept subscriptions inactive without a status requested in the state are flagged.
 func getBellatrixSubscriptionsDiff(
-	subscriptionDesiredState []*model.Subscription,
+	subscriptionDesiredState []*entities.SubscriptionDefinition,
 	subscriptionsInOrion []*model.Subscription,
-) ([]*model.Subscription, []*model.Subscription) {
-	subscriptionsToDelete := bellatrixSubscriptionsDifferenceByID(
-		subscriptionsInOrion,
-		subscriptionDesiredState,
-	)
-	subscriptionsToAdd := bellatrixSubscriptionsDifferenceByID(
-		subscriptionDesiredState,
-		subscriptionsInOrion,
-	)
-	return subscriptionsToAdd, subscriptionsToDelete
-}
+	instancePrefix string,
+) *entities.SubscriptionsPatch {
+	patch := &entities.SubscriptionsPatch{}
 
-func bellatrixSubscriptionsDifferenceByID(
-	subsA []*model.Subscription,
-	subsB []*model.Subscription,
-) []*model.Subscription {
-	var diff []*model.Subscription
+	subsInOrionMap := make(map[string][]*model.Subscription)
+	for _, item := range subscriptionsInOrion {
+		name, _ := managedSubscriptionName(item.Description, instancePrefix)
+		subsInOrionMap[name] = append(subsInOrionMap[name], item)
+	}
 
-	subsMap := make(map[string]bool)
+	desiredNames := make(map[string]bool)
+	for _, definition := range subscriptionDesiredState {
+		desired := definition.Subscription
+		name, _ := managedSubscriptionName(desired.Description, instancePrefix)
+		desiredNames[name] = true
 
-	for _, item := range subsB {
-		subsMap[item.Description] = true
+		candidates, ok := subsInOrionMap[name]
+		if !ok {
+			patch.SubscriptionsToAdd = append(patch.SubscriptionsToAdd, desired)
+			continue
+		}
+
+		inOrion, duplicates := pickSubscriptionToKeep(candidates, definition)
+		patch.DuplicatesToDelete = append(patch.DuplicatesToDelete, duplicates...)
+		if isSubscriptionInactiveOnItsOwn(inOrion, desired) {
+			patch.InactiveSubscriptions = append(patch.InactiveSubscriptions, inOrion)
+		}
+
+		changes := getSubscriptionChanges(desired, inOrion)
+		if len(changes) == 0 {
+			continue
+		}
+
+		if subscriptionRequiresReplacement(desired, inOrion) {
+			patch.SubscriptionsToDelete = append(patch.SubscriptionsToDelete, inOrion)
+			patch.SubscriptionsToAdd = append(patch.SubscriptionsToAdd, desired)
+			continue
+		}
+
+		patch.SubscriptionsToUpdate = append(patch.SubscriptionsToUpdate, &entities.SubscriptionUpdate{
+			Current: inOrion,
+			Desired: desired,
+			Changes: changes,
+		})
 	}
 
-	for _, item := range subsA {
-		if _, ok := subsMap[item.Description]; !ok {
-			diff = append(diff, item)
+	for _, inOrion := range subscriptionsInOrion {
+		name, _ := managedSubscriptionName(inOrion.Description, instancePrefix)
+		if !desiredNames[name] {
+			patch.SubscriptionsToDelete = append(patch.SubscriptionsToDelete, inOrion)
 		}
 	}
-	return diff
+
+	return patch
+}
+
+// pickSubscriptionToKeep chooses, between subscriptions with the same description,
+// the one to keep: a healthy subscription is preferred over a failed one,
+// then an active one, or inactive as requested, over the others, then the oldest one.
+// The failure policy of the definition is applied without the fails counter.
+// Orion ids are mongo object ids, starting with the creation timestamp,
+// so the lowest id is the oldest subscription.
+func pickSubscriptionToKeep(
+	candidates []*model.Subscription,
+	definition *entities.SubscriptionDefinition,
+) (*model.Subscription, []*model.Subscription) {
+	desired := definition.Subscription
+	sortedCandidates := make([]*model.Subscription, len(candidates))
+	copy(sortedCandidates, candidates)
+	sort.SliceStable(sortedCandidates, func(i, j int) bool {
+		iFailed := isSubscriptionFailed(sortedCandidates[i], definition, nil)
+		jFailed := isSubscriptionFailed(sortedCandidates[j], definition, nil)
+		if iFailed != jFailed {
+			return !iFailed
+		}
+		iActive := sortedCandidates[i].Status == "" || sortedCandidates[i].Status == model.SubscriptionActive ||
+			isSubscriptionInactiveOnPurpose(sortedCandidates[i], desired)
+		jActive := sortedCandidates[j].Status == "" || sortedCandidates[j].Status == model.SubscriptionActive ||
+			isSubscriptionInactiveOnPurpose(sortedCandidates[j], desired)
+		if iActive != jActive {
+			return iActive
+		}
+		return sortedCandidates[i].Id < sortedCandidates[j].Id
+	})
+
+	return sortedCandidates[0], sortedCandidates[1:]
 }
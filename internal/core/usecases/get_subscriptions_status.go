@@ -0,0 +1,158 @@
+package usecases
+
+import (
+	"context"
+	"time"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// GetSubscriptionsStatus reports the health of the managed subscriptions
+// of the scopes in the state, without changing anything on the context broker
+type GetSubscriptionsStatus struct {
+	getAvailableSubscriptions *GetAvailableSubscriptions
+	getSubscriptionsHealth    *GetSubscriptionsHealth
+	logger                    *zap.Logger
+	instancePrefix            string
+	parallelism               int
+}
+
+func NewGetSubscriptionsStatus(
+	getAvailableSubscriptions *GetAvailableSubscriptions,
+	getSubscriptionsHealth *GetSubscriptionsHealth,
+	logger *zap.Logger,
+	instancePrefix string,
+	parallelism int,
+) *GetSubscriptionsStatus {
+	return &GetSubscriptionsStatus{
+		getAvailableSubscriptions: getAvailableSubscriptions,
+		getSubscriptionsHealth:    getSubscriptionsHealth,
+		logger:                    logger,
+		instancePrefix:            instancePrefix,
+		parallelism:               parallelism,
+	}
+}
+
+// Execute returns a report for every managed subscription found on the context broker,
+// and for every subscription of the state missing on it, in the order of the state
+func (u *GetSubscriptionsStatus) Execute(
+	ctx context.Context,
+	requestedSubscriptions []entities.SubscriptionRequest,
+) ([]*entities.SubscriptionStatusReport, error) {
+	requestsReports := make([][]*entities.SubscriptionStatusReport, len(requestedSubscriptions))
+
+	groups := groupByScope(len(requestedSubscriptions), func(i int) entities.SubscriptionsScope {
+		return requestScope(requestedSubscriptions[i])
+	})
+	tasks := make([]scopeTask, len(groups))
+	for g, group := range groups {
+		group := group
+		tasks[g] = func(logger *zap.Logger) error {
+			for _, i := range group {
+				reports, err := u.getRequestStatus(ctx, requestedSubscriptions[i])
+				if err != nil {
+					return err
+				}
+				requestsReports[i] = reports
+			}
+			return nil
+		}
+	}
+
+	err := firstError(runScopeTasks(ctx, tasks, u.parallelism, u.logger, true))
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []*entities.SubscriptionStatusReport
+	for _, requestReports := range requestsReports {
+		reports = append(reports, requestReports...)
+	}
+	return reports, nil
+}
+
+func (u *GetSubscriptionsStatus) getRequestStatus(
+	ctx context.Context,
+	request entities.SubscriptionRequest,
+) ([]*entities.SubscriptionStatusReport, error) {
+	subscriptionsInOrion, err := u.getAvailableSubscriptions.Execute(
+		ctx,
+		request.FiwareService,
+		request.ServicePath,
+	)
+	if err != nil {
+		return nil, errors.Wrapf(
+			err,
+			"could not get subscriptions on context broker for servicePath %s, and fiwareService %s",
+			request.ServicePath,
+			request.FiwareService,
+		)
+	}
+
+	orionSubsManagedByBellatrix := getSubscriptionsManagedByBellatrix(subscriptionsInOrion, u.instancePrefix)
+
+	var subscriptionsHealth map[string]*entities.SubscriptionHealth
+	if len(orionSubsManagedByBellatrix) > 0 {
+		subscriptionsHealth, err = u.getSubscriptionsHealth.Execute(ctx, request.FiwareService, request.ServicePath)
+		if err != nil {
+			return nil, errors.Wrapf(
+				err,
+				"could not get subscriptions health for servicePath %s, and fiwareService %s",
+				request.ServicePath,
+				request.FiwareService,
+			)
+		}
+	}
+
+	now := time.Now()
+	var reports []*entities.SubscriptionStatusReport
+	found := make(map[string]bool)
+	for _, sub := range orionSubsManagedByBellatrix {
+		name, _ := managedSubscriptionName(sub.Description, u.instancePrefix)
+		found[name] = true
+
+		// the definition is nil when the subscription is not in the state
+		definition, _ := findSubscriptionInsideSubState(request.Subscriptions, sub.Description, u.instancePrefix)
+		health := subscriptionsHealth[sub.Id]
+		evidence := subscriptionFailure(sub, definition, health, now)
+
+		report := &entities.SubscriptionStatusReport{
+			FiwareService:   request.FiwareService,
+			ServicePath:     request.ServicePath,
+			SubscriptionID:  sub.Id,
+			Description:     name,
+			Status:          string(sub.Status),
+			Failed:          evidence != "",
+			FailureEvidence: evidence,
+		}
+		if sub.Notification != nil {
+			report.TimesSent = sub.Notification.TimesSent
+			report.LastNotification = sub.Notification.LastNotification
+			report.LastSuccess = sub.Notification.LastSuccess
+			report.LastFailure = sub.Notification.LastFailure
+			report.LastSuccessCode = sub.Notification.LastSuccessCode
+		}
+		if health != nil {
+			report.LastFailureReason = health.LastFailureReason
+			report.FailsCounter = health.FailsCounter
+		}
+		reports = append(reports, report)
+	}
+
+	for _, definition := range request.Subscriptions {
+		name, _ := managedSubscriptionName(definition.Description, u.instancePrefix)
+		if found[name] {
+			continue
+		}
+		reports = append(reports, &entities.SubscriptionStatusReport{
+			FiwareService: request.FiwareService,
+			ServicePath:   request.ServicePath,
+			Description:   name,
+			Missing:       true,
+		})
+	}
+
+	return reports, nil
+}
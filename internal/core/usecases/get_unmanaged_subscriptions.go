@@ -0,0 +1,68 @@
+package usecases
+
+import (
+	"context"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/phoops/ngsiv2/model"
+	"github.com/pkg/errors"
+)
+
+// GetUnmanagedSubscriptions returns the subscriptions of a scope not managed
+// by this bellatrix instance, like the ones created by hand
+type GetUnmanagedSubscriptions struct {
+	getAvailableSubscriptions *GetAvailableSubscriptions
+	instancePrefix            string
+}
+
+func NewGetUnmanagedSubscriptions(
+	getAvailableSubscriptions *GetAvailableSubscriptions,
+	instancePrefix string,
+) *GetUnmanagedSubscriptions {
+	return &GetUnmanagedSubscriptions{getAvailableSubscriptions: getAvailableSubscriptions, instancePrefix: instancePrefix}
+}
+
+func (u *GetUnmanagedSubscriptions) Execute(
+	ctx context.Context,
+	scope entities.SubscriptionsScope,
+) ([]*model.Subscription, error) {
+	subscriptionsInOrion, err := u.getAvailableSubscriptions.Execute(
+		ctx,
+		scope.FiwareService,
+		scope.ServicePath,
+	)
+
+	if err != nil {
+		return nil, errors.Wrapf(
+			err,
+			"could not get subscriptions on context broker for servicePath %s, and fiwareService %s",
+			scope.ServicePath,
+			scope.FiwareService,
+		)
+	}
+
+	var unmanagedSubscriptions []*model.Subscription
+	for _, sub := range subscriptionsInOrion {
+		if _, managed := managedSubscriptionName(sub.Description, u.instancePrefix); !managed {
+			unmanagedSubscriptions = append(unmanagedSubscriptions, sub)
+		}
+	}
+
+	return unmanagedSubscriptions, nil
+}
+
+// stateSubscription returns the definition of a subscription found on the context broker
+// as it would be written in the state file: without the fields populated by orion,
+// without the id, and without the bellatrix prefix, protected when the prefix says so
+func stateSubscription(sub *model.Subscription, instancePrefix string) *entities.SubscriptionDefinition {
+	definition := requestableSubscription(sub)
+	definition.Description, _ = managedSubscriptionName(definition.Description, instancePrefix)
+	// active is the default status, no need to request it
+	if definition.Status == model.SubscriptionActive {
+		definition.Status = ""
+	}
+	return &entities.SubscriptionDefinition{
+		Subscription:   definition,
+		PreventDestroy: isSubscriptionProtected(sub, instancePrefix),
+	}
+}
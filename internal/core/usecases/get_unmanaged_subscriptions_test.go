@@ -0,0 +1,32 @@
+package usecases
+
+import (
+	"testing"
+
+	"github.com/phoops/ngsiv2/model"
+)
+
+func TestStateSubscriptionStatus(t *testing.T) {
+	tests := []struct {
+		inOrion  model.SubscriptionStatus
+		expected model.SubscriptionStatus
+	}{
+		{inOrion: model.SubscriptionActive, expected: ""},
+		{inOrion: model.SubscriptionInactive, expected: model.SubscriptionInactive},
+		{inOrion: SubscriptionOneshot, expected: SubscriptionOneshot},
+		{inOrion: "expired", expected: ""},
+		{inOrion: "failed", expected: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.inOrion), func(t *testing.T) {
+			definition := stateSubscription(&model.Subscription{Status: test.inOrion}, "")
+			if definition.Status != test.expected {
+				t.Fatalf("expected status %q, got %q", test.expected, definition.Status)
+			}
+			if err := validateRequestedStatus(definition.Status); err != nil {
+				t.Fatalf("exported status not accepted by the state file: %v", err)
+			}
+		})
+	}
+}
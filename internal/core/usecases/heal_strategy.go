@@ -0,0 +1,55 @@
+package usecases
+
+import (
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/phoops/ngsiv2/model"
+	"github.com/pkg/errors"
+)
+
+// validateHealStrategy checks the heal strategy together with the failure policy of the subscription:
+// orion keeps the last failure of a reactivated subscription, so the reactivation requires
+// a policy with FailureNewerThanSuccess, or the subscription would be reactivated at every run
+func validateHealStrategy(strategy string, policy *entities.FailurePolicy) error {
+	switch strategy {
+	case "", entities.HealStrategyRecreate:
+		return nil
+	case entities.HealStrategyReactivate:
+		if policy == nil || !policy.FailureNewerThanSuccess {
+			return errors.Errorf(
+				"heal strategy %s requires a failure policy with failure_newer_than_success set to true",
+				strategy,
+			)
+		}
+		return nil
+	}
+	return errors.Errorf(
+		"invalid heal strategy %q, use %s or %s",
+		strategy,
+		entities.HealStrategyReactivate,
+		entities.HealStrategyRecreate,
+	)
+}
+
+// healStrategy returns the strategy used to heal the failed subscription,
+// a subscription is recreated unless its definition requests otherwise
+func healStrategy(definition *entities.SubscriptionDefinition) string {
+	if definition == nil || definition.HealStrategy == "" {
+		return entities.HealStrategyRecreate
+	}
+	return definition.HealStrategy
+}
+
+// reactivationRequest returns the body of the PATCH request reactivating a failed
+// subscription: the requested status, active by default, and the requested notification,
+// without the fields populated by orion. A subscription not in the state is only reactivated.
+func reactivationRequest(definition *entities.SubscriptionDefinition) *model.Subscription {
+	request := &model.Subscription{Status: model.SubscriptionActive}
+	if definition == nil {
+		return request
+	}
+	if definition.Status != "" {
+		request.Status = definition.Status
+	}
+	request.Notification = comparableSubscription(definition.Subscription).Notification
+	return request
+}
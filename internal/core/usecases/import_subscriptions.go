@@ -0,0 +1,170 @@
+package usecases
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/phoops/ngsiv2/model"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+type SubscriptionsFileWriter interface {
+	WriteSubscriptionFile(path string, subsState *entities.SubscriptionsRequestedState) error
+}
+
+// ImportSubscriptions adopts subscriptions created outside of bellatrix:
+// their definitions are appended to the state file, then they are renamed
+// with the bellatrix prefix, keeping their ids, so there is no gap in notifications
+type ImportSubscriptions struct {
+	getUnmanagedSubscriptions *GetUnmanagedSubscriptions
+	fileParser                SubscriptionsFileParser
+	fileWriter                SubscriptionsFileWriter
+	updateSubscription        *UpdateSubscription
+	logger                    *zap.Logger
+	instancePrefix            string
+}
+
+func NewImportSubscriptions(
+	getUnmanagedSubscriptions *GetUnmanagedSubscriptions,
+	fileParser SubscriptionsFileParser,
+	fileWriter SubscriptionsFileWriter,
+	updateSubscription *UpdateSubscription,
+	logger *zap.Logger,
+	instancePrefix string,
+) *ImportSubscriptions {
+	return &ImportSubscriptions{
+		getUnmanagedSubscriptions: getUnmanagedSubscriptions,
+		fileParser:                fileParser,
+		fileWriter:                fileWriter,
+		updateSubscription:        updateSubscription,
+		logger:                    logger,
+		instancePrefix:            instancePrefix,
+	}
+}
+
+// Execute imports the unmanaged subscriptions of the scope selected by id
+// or by description pattern, and returns the imported subscriptions
+func (u *ImportSubscriptions) Execute(
+	ctx context.Context,
+	stateFilePath string,
+	scope entities.SubscriptionsScope,
+	ids []string,
+	descriptionPattern *regexp.Regexp,
+) ([]*model.Subscription, error) {
+	if len(ids) == 0 && descriptionPattern == nil {
+		return nil, errors.New("no subscriptions selected, provide ids or a description pattern")
+	}
+
+	// the state file is read without the bellatrix prefix, as the user wrote it
+	subsState, err := u.fileParser.ParseSubscriptionFile(stateFilePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse subscription file.")
+	}
+
+	unmanagedSubscriptions, err := u.getUnmanagedSubscriptions.Execute(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	selectedIDs := make(map[string]bool)
+	for _, id := range ids {
+		selectedIDs[id] = true
+	}
+	var selectedSubscriptions []*model.Subscription
+	for _, sub := range unmanagedSubscriptions {
+		if selectedIDs[sub.Id] || (descriptionPattern != nil && descriptionPattern.MatchString(sub.Description)) {
+			selectedSubscriptions = append(selectedSubscriptions, sub)
+			delete(selectedIDs, sub.Id)
+		}
+	}
+	if len(selectedIDs) != 0 {
+		var missingIDs []string
+		for id := range selectedIDs {
+			missingIDs = append(missingIDs, id)
+		}
+		sort.Strings(missingIDs)
+		return nil, errors.Errorf(
+			"could not find unmanaged subscriptions with ids %s in the scope",
+			strings.Join(missingIDs, ", "),
+		)
+	}
+	if len(selectedSubscriptions) == 0 {
+		return nil, nil
+	}
+
+	request := findOrAppendSubscriptionRequest(subsState, scope)
+	stateDescriptions := make(map[string]bool)
+	for _, sub := range request.Subscriptions {
+		stateDescriptions[sub.Description] = true
+	}
+	for _, sub := range selectedSubscriptions {
+		if sub.Description == "" {
+			return nil, errors.Errorf("subscription with id %s has no description, it cannot be managed by bellatrix", sub.Id)
+		}
+		if stateDescriptions[sub.Description] {
+			return nil, errors.Errorf(
+				"a subscription with description %s is already in the state, or selected twice",
+				sub.Description,
+			)
+		}
+		stateDescriptions[sub.Description] = true
+		request.Subscriptions = append(request.Subscriptions, stateSubscription(sub, u.instancePrefix))
+	}
+
+	// the state is written before the renaming: if the renaming fails the next sync
+	// creates a copy of the subscription, instead of leaving the renamed subscriptions
+	// unclaimed and deleting them
+	err = u.fileWriter.WriteSubscriptionFile(stateFilePath, subsState)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not write subscription file")
+	}
+
+	fullPrefix := u.instancePrefix + BellatrixManagedSubscriptionsPrefix
+	for _, sub := range selectedSubscriptions {
+		u.logger.Info(
+			"Importing subscription",
+			zap.String("subscription_id", sub.Id),
+			zap.String("subscription_description", sub.Description),
+		)
+		err = u.updateSubscription.Execute(
+			ctx,
+			scope.FiwareService,
+			scope.ServicePath,
+			sub.Id,
+			&model.Subscription{Description: fullPrefix + sub.Description},
+		)
+
+		if err != nil {
+			return nil, errors.Wrapf(
+				err,
+				"could not rename the subscription with id %s, it is already in the state file, retry the import or rename it by hand",
+				sub.Id,
+			)
+		}
+	}
+
+	return selectedSubscriptions, nil
+}
+
+// findOrAppendSubscriptionRequest returns the subscription request of the scope,
+// adding an empty one to the state if the scope is not requested yet
+func findOrAppendSubscriptionRequest(
+	subsState *entities.SubscriptionsRequestedState,
+	scope entities.SubscriptionsScope,
+) *entities.SubscriptionRequest {
+	for i := range subsState.SubscriptionsState {
+		request := &subsState.SubscriptionsState[i]
+		if request.FiwareService == scope.FiwareService && request.ServicePath == scope.ServicePath {
+			return request
+		}
+	}
+	subsState.SubscriptionsState = append(subsState.SubscriptionsState, entities.SubscriptionRequest{
+		FiwareService: scope.FiwareService,
+		ServicePath:   scope.ServicePath,
+	})
+	return &subsState.SubscriptionsState[len(subsState.SubscriptionsState)-1]
+}
@@ -30,14 +30,81 @@ func (u *ParseSubscriptionsStateFile) Execute(path string) (*entities.Subscripti
 	}
 
 	// Attach the bellatrix prefix, to subs description, in order to distinguish
-	// on orion the subs managed by this  program
+	// on orion the subs managed by this  program, the protected subs
+	// have their own prefix.
+	// The subs without a failure policy or a heal strategy get the global ones
 
 	for _, subRequest := range subsState.SubscriptionsState {
-		for _, subs := range subRequest.Subscriptions {
+		for i, subs := range subRequest.Subscriptions {
+			if subs == nil || subs.Subscription == nil {
+				return nil, errors.Errorf(
+					"empty subscription at index %d for servicePath %s, and fiwareService %s",
+					i,
+					subRequest.ServicePath,
+					subRequest.FiwareService,
+				)
+			}
+			if err := validateRequestedStatus(subs.Status); err != nil {
+				return nil, errors.Wrapf(
+					err,
+					"invalid subscription %s for servicePath %s, and fiwareService %s",
+					subs.Description,
+					subRequest.ServicePath,
+					subRequest.FiwareService,
+				)
+			}
+			if subs.FailurePolicy == nil {
+				subs.FailurePolicy = subsState.FailurePolicy
+			}
+			if err := validateFailurePolicy(subs.FailurePolicy); err != nil {
+				return nil, errors.Wrapf(
+					err,
+					"invalid failure policy of subscription %s for servicePath %s, and fiwareService %s",
+					subs.Description,
+					subRequest.ServicePath,
+					subRequest.FiwareService,
+				)
+			}
+			if subs.HealStrategy == "" {
+				subs.HealStrategy = subsState.HealStrategy
+			}
+			if err := validateHealStrategy(subs.HealStrategy, subs.FailurePolicy); err != nil {
+				return nil, errors.Wrapf(
+					err,
+					"invalid subscription %s for servicePath %s, and fiwareService %s",
+					subs.Description,
+					subRequest.ServicePath,
+					subRequest.FiwareService,
+				)
+			}
 			fullPrefix := u.instancePrefix + BellatrixManagedSubscriptionsPrefix
+			if subs.PreventDestroy {
+				fullPrefix = u.instancePrefix + BellatrixProtectedSubscriptionsPrefix
+			}
 			subs.Description = fullPrefix + subs.Description
 		}
 	}
 
+	// Managed scopes without a subscription request are scopes removed from the state,
+	// we request an empty set of subscriptions for them, so the managed subscriptions
+	// still on the context broker are deleted
+	requestedScopes := make(map[entities.SubscriptionsScope]bool)
+	for _, subRequest := range subsState.SubscriptionsState {
+		requestedScopes[entities.SubscriptionsScope{
+			FiwareService: subRequest.FiwareService,
+			ServicePath:   subRequest.ServicePath,
+		}] = true
+	}
+	for _, scope := range subsState.ManagedScopes {
+		if requestedScopes[scope] {
+			continue
+		}
+		requestedScopes[scope] = true
+		subsState.SubscriptionsState = append(subsState.SubscriptionsState, entities.SubscriptionRequest{
+			FiwareService: scope.FiwareService,
+			ServicePath:   scope.ServicePath,
+		})
+	}
+
 	return subsState, nil
 }
@@ -0,0 +1,172 @@
+package usecases
+
+import (
+	"context"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultRetryAttempts is the number of times a request to the context broker
+	// is attempted before giving up
+	DefaultRetryAttempts = 3
+	// DefaultRetryInitialBackoff is the wait before the first retry,
+	// doubled at every following retry
+	DefaultRetryInitialBackoff = 500 * time.Millisecond
+	// DefaultRetryMaxBackoff caps the wait between two retries
+	DefaultRetryMaxBackoff = 10 * time.Second
+)
+
+// RetryPolicy defines how the requests to the context broker failed
+// with a transient error are retried
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// NewRetryPolicy returns a retry policy, a number of attempts lower than 1
+// means that the requests are attempted once
+func NewRetryPolicy(
+	maxAttempts int,
+	initialBackoff time.Duration,
+	maxBackoff time.Duration,
+) RetryPolicy {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	if maxBackoff < initialBackoff {
+		maxBackoff = initialBackoff
+	}
+	return RetryPolicy{
+		MaxAttempts:    maxAttempts,
+		InitialBackoff: initialBackoff,
+		MaxBackoff:     maxBackoff,
+	}
+}
+
+// backoff returns the wait before the given retry, starting from 1,
+// an exponential backoff with full jitter, so the workers retrying together
+// do not hit the context broker at the same time
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	ceiling := p.InitialBackoff
+	for i := 1; i < retry && ceiling < p.MaxBackoff; i++ {
+		ceiling *= 2
+	}
+	if ceiling > p.MaxBackoff {
+		ceiling = p.MaxBackoff
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// retry runs the operation until it succeeds, it fails with an error that is not
+// transient, the attempts are over or the context is done. Before every retry beforeRetry,
+// when set, is called with the last error: it can report that the operation is already done,
+// when a request failed but reached the context broker anyway.
+func (p RetryPolicy) retry(
+	ctx context.Context,
+	logger *zap.Logger,
+	operationName string,
+	operation func() error,
+	beforeRetry func(lastErr error) (bool, error),
+) error {
+	if err := ctx.Err(); err != nil {
+		return errors.Wrapf(err, "%s not attempted", operationName)
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = operation()
+		if err == nil || !isTransientBrokerError(err) || attempt >= p.MaxAttempts {
+			return err
+		}
+
+		wait := p.backoff(attempt)
+		logger.Warn(
+			"Transient error from context broker, retrying",
+			zap.String("operation", operationName),
+			zap.Int("attempt", attempt),
+			zap.Int("max_attempts", p.MaxAttempts),
+			zap.Duration("backoff", wait),
+			zap.Error(err),
+		)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Wrapf(ctx.Err(), "%s not retried after the error %v", operationName, err)
+		}
+
+		if beforeRetry != nil {
+			done, checkErr := beforeRetry(err)
+			if checkErr != nil {
+				return errors.Wrapf(checkErr, "could not check the outcome of %s after the error %v", operationName, err)
+			}
+			if done {
+				return nil
+			}
+		}
+	}
+}
+
+// the ngsiv2 client reports the unexpected responses only inside the error message
+var brokerStatusCodePattern = regexp.MustCompile(`^Unexpected status code: '(\d+)'`)
+
+// brokerStatusCode returns the http status code of the context broker response
+// that caused the error, if any
+func brokerStatusCode(err error) (int, bool) {
+	if err == nil {
+		return 0, false
+	}
+	var brokerErr *entities.BrokerError
+	if errors.As(err, &brokerErr) {
+		return brokerErr.StatusCode, brokerErr.StatusCode != 0
+	}
+	matches := brokerStatusCodePattern.FindStringSubmatch(errors.Cause(err).Error())
+	if matches == nil {
+		return 0, false
+	}
+	statusCode, convErr := strconv.Atoi(matches[1])
+	if convErr != nil {
+		return 0, false
+	}
+	return statusCode, true
+}
+
+// the ngsiv2 client wraps the network errors inside these messages
+var brokerNetworkErrorPrefixes = []string{
+	"Error invoking ",
+	"Could not retrieve ",
+	"Error reading retrieve subscription",
+}
+
+// isTransientBrokerError reports if the error is a network error,
+// a server error or a too many requests response, that could not happen again
+func isTransientBrokerError(err error) bool {
+	if statusCode, ok := brokerStatusCode(err); ok {
+		return statusCode >= 500 || statusCode == 429
+	}
+	var brokerErr *entities.BrokerError
+	if errors.As(err, &brokerErr) {
+		// no complete response received
+		return true
+	}
+	message := errors.Cause(err).Error()
+	for _, prefix := range brokerNetworkErrorPrefixes {
+		if strings.HasPrefix(message, prefix) {
+			return true
+		}
+	}
+	return false
+}
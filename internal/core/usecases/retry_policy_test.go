@@ -0,0 +1,62 @@
+package usecases
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/pkg/errors"
+)
+
+func TestIsTransientBrokerError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{
+			name:      "orion client server error",
+			err:       fmt.Errorf("Unexpected status code: '503'\nResponse body: "),
+			transient: true,
+		},
+		{
+			name: "orion client not found",
+			err:  fmt.Errorf("Unexpected status code: '404'\nResponse body: "),
+		},
+		{
+			name:      "orion client network error",
+			err:       fmt.Errorf("Could not retrieve subscriptions: connection refused"),
+			transient: true,
+		},
+		{
+			name:      "broker error without response",
+			err:       errors.Wrap(&entities.BrokerError{Operation: "read", Err: errors.New("connection refused")}, "wrapped"),
+			transient: true,
+		},
+		{
+			name:      "broker error too many requests",
+			err:       &entities.BrokerError{Operation: "read", StatusCode: 429, Err: errors.New("slow down")},
+			transient: true,
+		},
+		{
+			name: "broker error bad request",
+			err:  &entities.BrokerError{Operation: "read", StatusCode: 400, Err: errors.New("bad request")},
+		},
+		{
+			name: "broker error invalid body",
+			err:  &entities.BrokerError{Operation: "read", StatusCode: 200, Err: errors.New("invalid body")},
+		},
+		{
+			name: "other error",
+			err:  errors.New("boom"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if transient := isTransientBrokerError(test.err); transient != test.transient {
+				t.Fatalf("expected transient %v, got %v", test.transient, transient)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package usecases
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// scopeTask is a unit of work bound to a single fiware-service/service path
+type scopeTask func(logger *zap.Logger) error
+
+// runScopeTasks runs the tasks using at most parallelism workers and returns
+// the error of every task, in the tasks order.
+// With a parallelism greater than one every task logs into its own buffer, the buffers
+// are flushed in the tasks order, so the log output does not depend on the scheduling.
+// When stopOnError is set, the tasks not started yet are skipped after a failure.
+// When the context is done the tasks not started yet are skipped, and the context
+// error is returned for the first of them.
+func runScopeTasks(
+	ctx context.Context,
+	tasks []scopeTask,
+	parallelism int,
+	logger *zap.Logger,
+	stopOnError bool,
+) []error {
+	errs := make([]error, len(tasks))
+
+	if parallelism <= 1 || len(tasks) <= 1 {
+		for i, task := range tasks {
+			if err := ctx.Err(); err != nil {
+				errs[i] = errors.Wrap(err, "interrupted before all the scopes were processed")
+				break
+			}
+			errs[i] = task(logger)
+			if errs[i] != nil && stopOnError {
+				break
+			}
+		}
+		return errs
+	}
+
+	buffers := make([]*logBuffer, len(tasks))
+	started := make([]bool, len(tasks))
+	completed := make([]bool, len(tasks))
+	nextToFlush := 0
+	var flushMutex sync.Mutex
+	var stopped int32
+
+	indexes := make(chan int)
+	var workers sync.WaitGroup
+	for w := 0; w < parallelism && w < len(tasks); w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for i := range indexes {
+				buffers[i] = &logBuffer{}
+				if atomic.LoadInt32(&stopped) == 0 && ctx.Err() == nil {
+					started[i] = true
+					errs[i] = tasks[i](buffers[i].logger(logger))
+					if errs[i] != nil && stopOnError {
+						atomic.StoreInt32(&stopped, 1)
+					}
+				}
+
+				flushMutex.Lock()
+				completed[i] = true
+				for nextToFlush < len(tasks) && completed[nextToFlush] {
+					buffers[nextToFlush].flush(logger.Core())
+					nextToFlush++
+				}
+				flushMutex.Unlock()
+			}
+		}()
+	}
+
+	for i := range tasks {
+		indexes <- i
+	}
+	close(indexes)
+	workers.Wait()
+
+	if err := ctx.Err(); err != nil {
+		for i := range tasks {
+			if !started[i] {
+				errs[i] = errors.Wrap(err, "interrupted before all the scopes were processed")
+				break
+			}
+		}
+	}
+
+	return errs
+}
+
+// firstError returns the first not nil error
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// groupByScope returns, for every distinct scope, the indexes of the items
+// belonging to it, in the order of the first appearance of the scope.
+// The items of the same scope are handled by the same task, so their order is preserved.
+func groupByScope(n int, scopeOf func(i int) entities.SubscriptionsScope) [][]int {
+	var groups [][]int
+	groupIndexes := make(map[entities.SubscriptionsScope]int)
+	for i := 0; i < n; i++ {
+		scope := scopeOf(i)
+		groupIndex, ok := groupIndexes[scope]
+		if !ok {
+			groupIndex = len(groups)
+			groupIndexes[scope] = groupIndex
+			groups = append(groups, nil)
+		}
+		groups[groupIndex] = append(groups[groupIndex], i)
+	}
+	return groups
+}
+
+type bufferedLogEntry struct {
+	entry  zapcore.Entry
+	fields []zapcore.Field
+}
+
+// logBuffer keeps the log entries of a task until they can be written
+type logBuffer struct {
+	mutex   sync.Mutex
+	entries []*bufferedLogEntry
+}
+
+// logger returns a logger with the same options of the parent one,
+// writing its entries into the buffer
+func (b *logBuffer) logger(parent *zap.Logger) *zap.Logger {
+	return parent.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &bufferedCore{LevelEnabler: core, buffer: b}
+	}))
+}
+
+func (b *logBuffer) flush(core zapcore.Core) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, buffered := range b.entries {
+		// the entries have already been checked against the core level
+		_ = core.Write(buffered.entry, buffered.fields)
+	}
+	b.entries = nil
+	_ = core.Sync()
+}
+
+type bufferedCore struct {
+	zapcore.LevelEnabler
+	fields []zapcore.Field
+	buffer *logBuffer
+}
+
+func (c *bufferedCore) With(fields []zapcore.Field) zapcore.Core {
+	withFields := make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	withFields = append(withFields, c.fields...)
+	withFields = append(withFields, fields...)
+	return &bufferedCore{LevelEnabler: c.LevelEnabler, fields: withFields, buffer: c.buffer}
+}
+
+func (c *bufferedCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *bufferedCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	allFields := make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	allFields = append(allFields, c.fields...)
+	allFields = append(allFields, fields...)
+
+	c.buffer.mutex.Lock()
+	defer c.buffer.mutex.Unlock()
+	c.buffer.entries = append(c.buffer.entries, &bufferedLogEntry{entry: entry, fields: allFields})
+	return nil
+}
+
+func (c *bufferedCore) Sync() error {
+	return nil
+}
@@ -0,0 +1,207 @@
+package usecases
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/pkg/errors"
+)
+
+const (
+	SelectorFieldFiwareService = "fiware_service"
+	SelectorFieldServicePath   = "service_path"
+	SelectorFieldDescription   = "description"
+	// SelectorLabelPrefix is the prefix of the selector fields matching a label,
+	// e.g. label.team
+	SelectorLabelPrefix = "label."
+)
+
+// SelectorCondition matches a field of a subscription against a glob pattern,
+// * matches any sequence of characters and ? a single character
+type SelectorCondition struct {
+	Field   string
+	Pattern string
+	pattern *regexp.Regexp
+}
+
+// NewSelectorCondition returns a condition on a selector field,
+// fiware_service, service_path, description or label.<name>
+func NewSelectorCondition(field string, pattern string) (SelectorCondition, error) {
+	switch {
+	case field == SelectorFieldFiwareService,
+		field == SelectorFieldServicePath,
+		field == SelectorFieldDescription:
+	case strings.HasPrefix(field, SelectorLabelPrefix) && len(field) > len(SelectorLabelPrefix):
+	default:
+		return SelectorCondition{}, errors.Errorf(
+			"invalid selector field %q, use %s, %s, %s or %s<name>",
+			field,
+			SelectorFieldFiwareService,
+			SelectorFieldServicePath,
+			SelectorFieldDescription,
+			SelectorLabelPrefix,
+		)
+	}
+
+	return SelectorCondition{Field: field, Pattern: pattern, pattern: globRegexp(pattern)}, nil
+}
+
+// Selector matches the subscriptions satisfying all its conditions
+type Selector struct {
+	Conditions []SelectorCondition
+}
+
+// Selection restricts a run to a part of the subscriptions: a subscription is selected
+// when it matches at least one of the targets, or there are no targets,
+// and it does not match any of the excludes.
+// The zero value selects every subscription.
+type Selection struct {
+	Targets  []Selector
+	Excludes []Selector
+}
+
+// selectedSubscription identifies a subscription for the selection,
+// the description is without the bellatrix prefix, the labels are the ones
+// of the state file, a subscription no longer in the state has no labels
+type selectedSubscription struct {
+	scope       entities.SubscriptionsScope
+	description string
+	labels      map[string]string
+}
+
+func (s Selection) isEmpty() bool {
+	return len(s.Targets) == 0 && len(s.Excludes) == 0
+}
+
+func (s Selection) selects(sub selectedSubscription) bool {
+	if len(s.Targets) > 0 {
+		targeted := false
+		for _, target := range s.Targets {
+			if target.matches(sub) {
+				targeted = true
+				break
+			}
+		}
+		if !targeted {
+			return false
+		}
+	}
+	for _, exclude := range s.Excludes {
+		if exclude.matches(sub) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s Selector) matches(sub selectedSubscription) bool {
+	for _, condition := range s.Conditions {
+		if !condition.matches(sub) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c SelectorCondition) matches(sub selectedSubscription) bool {
+	var value string
+	switch c.Field {
+	case SelectorFieldFiwareService:
+		value = sub.scope.FiwareService
+	case SelectorFieldServicePath:
+		value = sub.scope.ServicePath
+	case SelectorFieldDescription:
+		value = sub.description
+	default:
+		label, ok := sub.labels[strings.TrimPrefix(c.Field, SelectorLabelPrefix)]
+		if !ok {
+			return false
+		}
+		value = label
+	}
+	return c.pattern.MatchString(value)
+}
+
+// globRegexp compiles a glob pattern, the whole value must match
+func globRegexp(pattern string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(pattern)
+	quoted = strings.ReplaceAll(quoted, `\*`, ".*")
+	quoted = strings.ReplaceAll(quoted, `\?`, ".")
+	return regexp.MustCompile("^" + quoted + "$")
+}
+
+// selectPatch moves the changes of the patch outside the selection to its skipped changes
+// and drops the inactive subscriptions outside the selection, so they are not reported,
+// the definitions in the state are used to find the labels of the subscriptions.
+// A replacement is a single change, its delete and create are always selected together
+// since they have the same description.
+func selectPatch(
+	patch *entities.SubscriptionsPatch,
+	selection Selection,
+	definitions []*entities.SubscriptionDefinition,
+	instancePrefix string,
+) {
+	if selection.isEmpty() {
+		return
+	}
+
+	scope := entities.SubscriptionsScope{FiwareService: patch.FiwareService, ServicePath: patch.ServicePath}
+	labels := make(map[string]map[string]string)
+	for _, definition := range definitions {
+		name, _ := managedSubscriptionName(definition.Description, instancePrefix)
+		labels[name] = definition.Labels
+	}
+	selects := func(description string) bool {
+		name, _ := managedSubscriptionName(description, instancePrefix)
+		return selection.selects(selectedSubscription{scope: scope, description: name, labels: labels[name]})
+	}
+	selected := func(operation string, id string, description string) bool {
+		if selects(description) {
+			return true
+		}
+		patch.Skipped = append(patch.Skipped, &entities.SkippedChange{
+			Operation:      operation,
+			SubscriptionID: id,
+			Description:    description,
+		})
+		return false
+	}
+
+	toAdd := patch.SubscriptionsToAdd[:0]
+	for _, sub := range patch.SubscriptionsToAdd {
+		if selected(entities.OperationCreate, "", sub.Description) {
+			toAdd = append(toAdd, sub)
+		}
+	}
+	toUpdate := patch.SubscriptionsToUpdate[:0]
+	for _, update := range patch.SubscriptionsToUpdate {
+		if selected(entities.OperationUpdate, update.Current.Id, update.Desired.Description) {
+			toUpdate = append(toUpdate, update)
+		}
+	}
+	toDelete := patch.SubscriptionsToDelete[:0]
+	for _, sub := range patch.SubscriptionsToDelete {
+		if selected(entities.OperationDelete, sub.Id, sub.Description) {
+			toDelete = append(toDelete, sub)
+		}
+	}
+	duplicatesToDelete := patch.DuplicatesToDelete[:0]
+	for _, sub := range patch.DuplicatesToDelete {
+		if selected(entities.OperationDelete, sub.Id, sub.Description) {
+			duplicatesToDelete = append(duplicatesToDelete, sub)
+		}
+	}
+	inactive := patch.InactiveSubscriptions[:0]
+	for _, sub := range patch.InactiveSubscriptions {
+		if selects(sub.Description) {
+			inactive = append(inactive, sub)
+		}
+	}
+
+	patch.SubscriptionsToAdd = toAdd
+	patch.SubscriptionsToUpdate = toUpdate
+	patch.SubscriptionsToDelete = toDelete
+	patch.DuplicatesToDelete = duplicatesToDelete
+	patch.InactiveSubscriptions = inactive
+}
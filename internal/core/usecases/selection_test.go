@@ -0,0 +1,55 @@
+package usecases
+
+import (
+	"context"
+	"testing"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+)
+
+func TestSelectionFiltersInactiveSubscriptions(t *testing.T) {
+	broker := newFakeBroker(t)
+	var definitions []*entities.SubscriptionDefinition
+	for _, name := range []string{"selected", "other"} {
+		broker.addSubscription(t, mustSubscription(t, `{
+			"description": "`+BellatrixManagedSubscriptionsPrefix+name+`",
+			"notification": {"http": {"url": "http://consumer/notify"}},
+			"status": "inactive"
+		}`))
+		definitions = append(definitions, &entities.SubscriptionDefinition{
+			Subscription: mustSubscription(t, `{
+				"description": "`+BellatrixManagedSubscriptionsPrefix+name+`",
+				"notification": {"http": {"url": "http://consumer/notify"}}
+			}`),
+		})
+	}
+	condition, err := NewSelectorCondition(SelectorFieldDescription, "selected")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scopeUsecases := newBrokerUsecases(t, broker)
+	patches, err := NewGetSubscriptionsPatches(
+		scopeUsecases.getAvailableSubscriptions,
+		testLogger(),
+		"",
+		1,
+		DestroyGuard{},
+		Selection{Targets: []Selector{{Conditions: []SelectorCondition{condition}}}},
+	).Execute(context.Background(), []entities.SubscriptionRequest{{
+		FiwareService: "Wolfsburg",
+		ServicePath:   "/WasteMGT",
+		Subscriptions: definitions,
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error computing the patches: %v", err)
+	}
+
+	if len(patches) != 1 {
+		t.Fatalf("expected a single patch, got %d", len(patches))
+	}
+	inactive := patches[0].InactiveSubscriptions
+	if len(inactive) != 1 || inactive[0].Description != BellatrixManagedSubscriptionsPrefix+"selected" {
+		t.Fatalf("expected only the selected inactive subscription, got %+v", inactive)
+	}
+}
@@ -0,0 +1,54 @@
+package usecases
+
+import (
+	"github.com/phoops/ngsiv2/model"
+	"github.com/pkg/errors"
+)
+
+// SubscriptionOneshot is the status of a subscription notifying only once,
+// orion moves it to inactive after the notification
+const SubscriptionOneshot model.SubscriptionStatus = "oneshot"
+
+// validateRequestedStatus checks the status requested in the state file,
+// the other statuses are set only by orion
+func validateRequestedStatus(status model.SubscriptionStatus) error {
+	switch status {
+	case "", model.SubscriptionActive, model.SubscriptionInactive, SubscriptionOneshot:
+		return nil
+	}
+	return errors.Errorf(
+		"invalid status %q, use %s, %s or %s",
+		status,
+		model.SubscriptionActive,
+		model.SubscriptionInactive,
+		SubscriptionOneshot,
+	)
+}
+
+// requestableSubscription returns the definition of a subscription found on the context broker
+// that can be sent back to it: without the fields populated by orion and without
+// the statuses set only by orion, like expired or failed, orion refuses them in a request
+func requestableSubscription(sub *model.Subscription) *model.Subscription {
+	requestable := comparableSubscription(sub)
+	if validateRequestedStatus(requestable.Status) != nil {
+		requestable.Status = ""
+	}
+	return requestable
+}
+
+// isSubscriptionInactiveOnPurpose reports if the subscription on the context broker
+// is inactive because the state requests it: an inactive subscription,
+// or a oneshot subscription that has already notified
+func isSubscriptionInactiveOnPurpose(inOrion *model.Subscription, desired *model.Subscription) bool {
+	if desired == nil || inOrion.Status != model.SubscriptionInactive {
+		return false
+	}
+	return desired.Status == model.SubscriptionInactive || desired.Status == SubscriptionOneshot
+}
+
+// isSubscriptionInactiveOnItsOwn reports if the subscription on the context broker
+// is inactive while the state does not request a status, a subscription requested
+// active is reactivated by the sync instead
+func isSubscriptionInactiveOnItsOwn(inOrion *model.Subscription, desired *model.Subscription) bool {
+	return desired != nil && desired.Status == "" && inOrion.Status == model.SubscriptionInactive
+}
@@ -0,0 +1,178 @@
+package usecases
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/phoops/ngsiv2/model"
+)
+
+// defaultAttrsFormat is the attrsFormat orion fills in when the subscription
+// does not specify one
+const defaultAttrsFormat = "normalized"
+
+// comparableSubscription returns a copy of the subscription containing only
+// the fields a user can request in the state file, so the fields populated
+// by orion at runtime (timesSent, lastNotification, lastSuccess...) and the
+// defaults orion fills in are not considered when comparing subscriptions.
+// The expiration is normalized to UTC, as orion returns it.
+func comparableSubscription(sub *model.Subscription) *model.Subscription {
+	comparable := &model.Subscription{
+		Description: sub.Description,
+		Subject:     sub.Subject,
+		Status:      sub.Status,
+		Throttling:  sub.Throttling,
+	}
+	if sub.Expires != nil {
+		comparable.Expires = &model.OrionTime{Time: sub.Expires.UTC()}
+	}
+
+	if sub.Notification != nil {
+		comparable.Notification = &model.SubscriptionNotification{
+			Attrs:       sub.Notification.Attrs,
+			ExceptAttrs: sub.Notification.ExceptAttrs,
+			Http:        sub.Notification.Http,
+			HttpCustom:  sub.Notification.HttpCustom,
+			AttrsFormat: sub.Notification.AttrsFormat,
+			Metadata:    sub.Notification.Metadata,
+		}
+		if comparable.Notification.AttrsFormat == defaultAttrsFormat {
+			comparable.Notification.AttrsFormat = ""
+		}
+	}
+
+	return comparable
+}
+
+// getSubscriptionChanges returns the fields that differ between the desired
+// subscription and the one found on the context broker.
+// Headers, query strings and lists are compared without regard to order.
+// The status is considered only when it is requested in the state, otherwise
+// it is owned by orion, a oneshot subscription that has notified and became
+// inactive is in sync.
+func getSubscriptionChanges(
+	desired *model.Subscription,
+	inOrion *model.Subscription,
+) []*entities.SubscriptionFieldChange {
+	desiredComparable := comparableSubscription(desired)
+	inOrionComparable := comparableSubscription(inOrion)
+	if desiredComparable.Status == "" {
+		inOrionComparable.Status = ""
+	}
+	if desiredComparable.Status == SubscriptionOneshot && inOrionComparable.Status == model.SubscriptionInactive {
+		inOrionComparable.Status = SubscriptionOneshot
+	}
+
+	desiredFields := flattenSubscription(desiredComparable)
+	inOrionFields := flattenSubscription(inOrionComparable)
+
+	fieldNames := make(map[string]bool)
+	for field := range desiredFields {
+		fieldNames[field] = true
+	}
+	for field := range inOrionFields {
+		fieldNames[field] = true
+	}
+
+	var changes []*entities.SubscriptionFieldChange
+	for field := range fieldNames {
+		before, after := inOrionFields[field], desiredFields[field]
+		if before != after {
+			changes = append(changes, &entities.SubscriptionFieldChange{
+				Field:  field,
+				Before: before,
+				After:  after,
+			})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+
+	return changes
+}
+
+// subscriptionRequiresReplacement reports if the desired subscription
+// removes fields that cannot be cleared with a PATCH request, because they are
+// omitted when empty, so the subscription must be deleted and recreated
+func subscriptionRequiresReplacement(
+	desired *model.Subscription,
+	inOrion *model.Subscription,
+) bool {
+	return (inOrion.Throttling != 0 && desired.Throttling == 0) ||
+		(inOrion.Expires != nil && desired.Expires == nil)
+}
+
+// flattenSubscription maps every leaf value of the subscription to its json path,
+// e.g. notification.httpCustom.headers.Authorization
+func flattenSubscription(sub *model.Subscription) map[string]string {
+	fields := make(map[string]string)
+
+	content, err := json.Marshal(sub)
+	if err != nil {
+		// a subscription that cannot be serialized cannot be sent to orion either,
+		// we keep it comparable anyway
+		fields[""] = fmt.Sprintf("%+v", sub)
+		return fields
+	}
+
+	var document interface{}
+	if err := json.Unmarshal(content, &document); err != nil {
+		fields[""] = string(content)
+		return fields
+	}
+
+	flattenValue("", document, fields)
+	return fields
+}
+
+func flattenValue(path string, value interface{}, fields map[string]string) {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		for key, item := range typedValue {
+			flattenValue(joinFieldPath(path, key), item, fields)
+		}
+	case []interface{}:
+		if len(typedValue) == 0 {
+			return
+		}
+		// lists are compared without regard to order
+		items := make([]string, 0, len(typedValue))
+		scalars := true
+		for _, item := range typedValue {
+			switch item.(type) {
+			case map[string]interface{}, []interface{}:
+				scalars = false
+			}
+			encodedItem, _ := json.Marshal(item)
+			items = append(items, string(encodedItem))
+		}
+		sort.Strings(items)
+		if scalars {
+			fields[path] = "[" + strings.Join(items, ", ") + "]"
+			return
+		}
+		for i, item := range items {
+			var decodedItem interface{}
+			_ = json.Unmarshal([]byte(item), &decodedItem)
+			flattenValue(fmt.Sprintf("%s[%d]", path, i), decodedItem, fields)
+		}
+	case nil:
+		return
+	case string:
+		fields[path] = typedValue
+	default:
+		encodedValue, _ := json.Marshal(typedValue)
+		fields[path] = string(encodedValue)
+	}
+}
+
+func joinFieldPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,136 @@
+package usecases
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/phoops/ngsiv2/model"
+)
+
+func mustSubscription(t *testing.T, content string) *model.Subscription {
+	t.Helper()
+	var sub model.Subscription
+	if err := json.Unmarshal([]byte(content), &sub); err != nil {
+		t.Fatalf("invalid subscription %s: %v", content, err)
+	}
+	return &sub
+}
+
+func TestGetSubscriptionChanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		desired string
+		inOrion string
+		changes []string
+	}{
+		{
+			name:    "same subscription",
+			desired: `{"description": "sub", "notification": {"http": {"url": "http://consumer"}}}`,
+			inOrion: `{"id": "1", "description": "sub", "notification": {"http": {"url": "http://consumer"},
+				"timesSent": 3, "lastSuccess": "2040-01-01T00:00:00.000Z", "lastSuccessCode": 200}}`,
+		},
+		{
+			name: "headers in a different order",
+			desired: `{"notification": {"httpCustom": {"url": "http://consumer",
+				"headers": {"a": "1", "b": "2", "c": "3"}}}}`,
+			inOrion: `{"notification": {"httpCustom": {"url": "http://consumer",
+				"headers": {"c": "3", "a": "1", "b": "2"}}}}`,
+		},
+		{
+			name:    "changed header",
+			desired: `{"notification": {"httpCustom": {"url": "http://consumer", "headers": {"a": "1"}}}}`,
+			inOrion: `{"notification": {"httpCustom": {"url": "http://consumer", "headers": {"a": "2"}}}}`,
+			changes: []string{"notification.httpCustom.headers.a"},
+		},
+		{
+			name: "lists in a different order",
+			desired: `{"subject": {"entities": [{"idPattern": ".*", "type": "A"}, {"idPattern": ".*", "type": "B"}],
+				"condition": {"attrs": ["x", "y"]}}, "notification": {"attrs": ["p", "q"]}}`,
+			inOrion: `{"subject": {"entities": [{"idPattern": ".*", "type": "B"}, {"idPattern": ".*", "type": "A"}],
+				"condition": {"attrs": ["y", "x"]}}, "notification": {"attrs": ["q", "p"]}}`,
+		},
+		{
+			name:    "changed list",
+			desired: `{"notification": {"attrs": ["p", "q"]}}`,
+			inOrion: `{"notification": {"attrs": ["p"]}}`,
+			changes: []string{"notification.attrs"},
+		},
+		{
+			name:    "default attrsFormat filled in by orion",
+			desired: `{"notification": {"http": {"url": "http://consumer"}}}`,
+			inOrion: `{"notification": {"http": {"url": "http://consumer"}, "attrsFormat": "normalized"}}`,
+		},
+		{
+			name:    "attrsFormat different from the default",
+			desired: `{"notification": {"http": {"url": "http://consumer"}, "attrsFormat": "keyValues"}}`,
+			inOrion: `{"notification": {"http": {"url": "http://consumer"}, "attrsFormat": "normalized"}}`,
+			changes: []string{"notification.attrsFormat"},
+		},
+		{
+			name:    "status not requested",
+			desired: `{"description": "sub"}`,
+			inOrion: `{"description": "sub", "status": "inactive"}`,
+		},
+		{
+			name:    "status requested inactive",
+			desired: `{"description": "sub", "status": "inactive"}`,
+			inOrion: `{"description": "sub", "status": "active"}`,
+			changes: []string{"status"},
+		},
+		{
+			name:    "status requested active",
+			desired: `{"description": "sub", "status": "active"}`,
+			inOrion: `{"description": "sub", "status": "inactive"}`,
+			changes: []string{"status"},
+		},
+		{
+			name:    "oneshot already notified",
+			desired: `{"description": "sub", "status": "oneshot"}`,
+			inOrion: `{"description": "sub", "status": "inactive"}`,
+		},
+		{
+			name:    "oneshot not notified yet",
+			desired: `{"description": "sub", "status": "oneshot"}`,
+			inOrion: `{"description": "sub", "status": "oneshot"}`,
+		},
+		{
+			name:    "oneshot requested on an active subscription",
+			desired: `{"description": "sub", "status": "oneshot"}`,
+			inOrion: `{"description": "sub", "status": "active"}`,
+			changes: []string{"status"},
+		},
+		{
+			name:    "expires with a time zone",
+			desired: `{"expires": "2040-01-01T16:00:00+02:00"}`,
+			inOrion: `{"expires": "2040-01-01T14:00:00.000Z"}`,
+		},
+		{
+			name:    "changed expires",
+			desired: `{"expires": "2040-01-01T16:00:00+02:00"}`,
+			inOrion: `{"expires": "2040-01-01T16:00:00.000Z"}`,
+			changes: []string{"expires"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changes := getSubscriptionChanges(
+				mustSubscription(t, test.desired),
+				mustSubscription(t, test.inOrion),
+			)
+
+			var fields []string
+			for _, change := range changes {
+				fields = append(fields, change.Field)
+			}
+			if len(fields) != len(test.changes) {
+				t.Fatalf("expected changes %v, got %v", test.changes, fields)
+			}
+			for i := range fields {
+				if fields[i] != test.changes[i] {
+					t.Fatalf("expected changes %v, got %v", test.changes, fields)
+				}
+			}
+		})
+	}
+}
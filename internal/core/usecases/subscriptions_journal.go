@@ -0,0 +1,132 @@
+package usecases
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/phoops/ngsiv2/model"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+)
+
+type subscriptionOperation string
+
+const (
+	subscriptionCreated subscriptionOperation = "create"
+	subscriptionUpdated subscriptionOperation = "update"
+	subscriptionDeleted subscriptionOperation = "delete"
+)
+
+// subscriptionMutation is a change applied on the context broker, previous is the
+// subscription as it was before an update or a delete
+type subscriptionMutation struct {
+	operation      subscriptionOperation
+	fiwareService  string
+	servicePath    string
+	subscriptionID string
+	description    string
+	previous       *model.Subscription
+}
+
+// subscriptionsJournal records the changes applied during a run,
+// so they can be compensated when the run fails
+type subscriptionsJournal struct {
+	mutations []*subscriptionMutation
+}
+
+func (j *subscriptionsJournal) record(mutation *subscriptionMutation) {
+	j.mutations = append(j.mutations, mutation)
+}
+
+// rollback compensates the recorded changes in reverse order: created subscriptions
+// are deleted, updated subscriptions are patched back to their previous definition
+// and deleted subscriptions are recreated from the captured definition, with a new id.
+// Every compensation is attempted, the errors are collected.
+func (j *subscriptionsJournal) rollback(
+	ctx context.Context,
+	createSubscription *CreateSubscription,
+	updateSubscription *UpdateSubscription,
+	deleteSubscription *DeleteSubscription,
+	logger *zap.Logger,
+) error {
+	var rollbackErr error
+
+	for i := len(j.mutations) - 1; i >= 0; i-- {
+		mutation := j.mutations[i]
+
+		var err error
+		switch mutation.operation {
+		case subscriptionCreated:
+			err = deleteSubscription.Execute(ctx, mutation.fiwareService, mutation.servicePath, mutation.subscriptionID)
+		case subscriptionUpdated:
+			// fields added by the update and omitted when empty, like throttling,
+			// cannot be cleared with a PATCH and stay on the subscription,
+			// a status set only by orion, like failed, is left to orion
+			err = updateSubscription.Execute(
+				ctx,
+				mutation.fiwareService,
+				mutation.servicePath,
+				mutation.subscriptionID,
+				subscriptionUpdateRequest(requestableSubscription(mutation.previous)),
+			)
+		case subscriptionDeleted:
+			_, err = createSubscription.Execute(
+				ctx,
+				mutation.fiwareService,
+				mutation.servicePath,
+				requestableSubscription(mutation.previous),
+			)
+		}
+
+		if err != nil {
+			logger.Error(
+				"Could not roll back change",
+				zap.String("operation", string(mutation.operation)),
+				zap.String("subscription_id", mutation.subscriptionID),
+				zap.String("subscription_description", mutation.description),
+				zap.Error(err),
+			)
+			rollbackErr = multierr.Append(rollbackErr, errors.Wrapf(
+				err,
+				"could not roll back the %s of subscription with description %s",
+				mutation.operation,
+				mutation.description,
+			))
+			continue
+		}
+
+		logger.Info(
+			"Rolled back change",
+			zap.String("operation", string(mutation.operation)),
+			zap.String("subscription_id", mutation.subscriptionID),
+			zap.String("subscription_description", mutation.description),
+		)
+	}
+
+	return rollbackErr
+}
+
+// TransactionError is returned by a transactional apply that failed,
+// it reports if the rollback of the changes already applied succeeded
+type TransactionError struct {
+	Err         error
+	RollbackErr error
+}
+
+func (e *TransactionError) Error() string {
+	if e.RollbackErr != nil {
+		return fmt.Sprintf("%v; rollback failed: %v", e.Err, e.RollbackErr)
+	}
+	return fmt.Sprintf("%v; rollback succeeded", e.Err)
+}
+
+// Cause returns the error that made the apply fail
+func (e *TransactionError) Cause() error {
+	return e.Err
+}
+
+// RolledBack reports if all the changes have been rolled back
+func (e *TransactionError) RolledBack() bool {
+	return e.RollbackErr == nil
+}
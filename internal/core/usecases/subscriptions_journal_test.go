@@ -0,0 +1,77 @@
+package usecases
+
+import (
+	"context"
+	"testing"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/phoops/ngsiv2/model"
+	"github.com/pkg/errors"
+)
+
+func TestTransactionalRollbackOfSubscriptionsFailedInOrion(t *testing.T) {
+	broker := newFakeBroker(t)
+	updated := mustSubscription(t, `{
+		"description": "`+BellatrixManagedSubscriptionsPrefix+`updated",
+		"notification": {"http": {"url": "http://consumer/notify"}, "lastFailure": "2040-01-01T10:00:00.000Z"},
+		"status": "failed"
+	}`)
+	broker.addSubscription(t, updated)
+	deleted := mustSubscription(t, `{
+		"description": "`+BellatrixManagedSubscriptionsPrefix+`deleted",
+		"notification": {"http": {"url": "http://consumer/notify"}},
+		"expires": "2030-01-01T00:00:00.000Z",
+		"status": "expired"
+	}`)
+	broker.addSubscription(t, deleted)
+
+	desired := mustSubscription(t, `{
+		"description": "`+BellatrixManagedSubscriptionsPrefix+`updated",
+		"notification": {"http": {"url": "http://consumer/v2/notify"}}
+	}`)
+	patches := []*entities.SubscriptionsPatch{
+		{
+			FiwareService: "Wolfsburg",
+			ServicePath:   "/WasteMGT",
+			SubscriptionsToUpdate: []*entities.SubscriptionUpdate{
+				{Current: updated, Desired: desired, Changes: getSubscriptionChanges(desired, updated)},
+			},
+			SubscriptionsToDelete: []*model.Subscription{deleted},
+		},
+		// the update of a subscription missing from the context broker fails the apply
+		{
+			FiwareService: "Wolfsburg",
+			ServicePath:   "/Parking",
+			SubscriptionsToUpdate: []*entities.SubscriptionUpdate{
+				{Current: &model.Subscription{Id: "missing"}, Desired: desired},
+			},
+		},
+	}
+
+	scopeUsecases := newBrokerUsecases(t, broker)
+	_, err := NewApplySubscriptionsPatches(
+		scopeUsecases.createSubscription,
+		scopeUsecases.updateSubscription,
+		scopeUsecases.deleteSubscription,
+		testLogger(),
+		ApplyModeTransactional,
+		1,
+	).Execute(context.Background(), patches)
+
+	var transactionErr *TransactionError
+	if !errors.As(err, &transactionErr) {
+		t.Fatalf("expected a transaction error, got %v", err)
+	}
+	if transactionErr.RollbackErr != nil {
+		t.Fatalf("unexpected rollback error: %v", transactionErr.RollbackErr)
+	}
+
+	rolledBack := broker.subscription(t, updated.Description)
+	if rolledBack == nil || rolledBack.Notification.Http.Url != "http://consumer/notify" {
+		t.Fatalf("expected the update to be rolled back, got %+v", rolledBack)
+	}
+	recreated := broker.subscription(t, deleted.Description)
+	if recreated == nil || recreated.Expires == nil {
+		t.Fatalf("expected the deleted subscription to be recreated, got %+v", recreated)
+	}
+}
@@ -0,0 +1,53 @@
+package usecases
+
+import (
+	"context"
+
+	"github.com/phoops/ngsiv2/client"
+	"github.com/phoops/ngsiv2/model"
+	"go.uber.org/zap"
+)
+
+type UpdateSubscription struct {
+	orionClient *client.NgsiV2Client
+	retryPolicy RetryPolicy
+	logger      *zap.Logger
+}
+
+// NewUpdateSubscription returns a new configured UpdateSubscription usecase
+func NewUpdateSubscription(
+	orionClient *client.NgsiV2Client,
+	retryPolicy RetryPolicy,
+	logger *zap.Logger,
+) *UpdateSubscription {
+	return &UpdateSubscription{
+		orionClient: orionClient,
+		retryPolicy: retryPolicy,
+		logger:      logger,
+	}
+}
+
+// Execute patches the subscription with the given fields,
+// a PATCH is idempotent so it is retried as is
+func (u *UpdateSubscription) Execute(
+	ctx context.Context,
+	fiwareService string,
+	servicePath string,
+	id string,
+	subscription *model.Subscription,
+) error {
+	return u.retryPolicy.retry(
+		ctx,
+		u.logger,
+		"update subscription "+id,
+		func() error {
+			return u.orionClient.UpdateSubscription(
+				id,
+				subscription,
+				client.SubscriptionSetFiwareService(fiwareService),
+				client.SubscriptionSetFiwareServicePath(servicePath),
+			)
+		},
+		nil,
+	)
+}
@@ -0,0 +1,156 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/phoops/ngsiv2/client"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// HealthReader reads the notification fields of the subscriptions the orion client
+// does not expose, calling the subscriptions api of the context broker directly
+type HealthReader struct {
+	orionClient       *client.NgsiV2Client
+	baseURL           string
+	additionalHeaders map[string]string
+	httpClient        *http.Client
+	logger            *zap.Logger
+
+	mu               sync.Mutex
+	subscriptionsURL string
+}
+
+// NewHealthReader returns a reader for the context broker at baseURL, every request
+// carries the additional headers and is bounded by the request timeout.
+// The subscriptions api is discovered through the orion client
+func NewHealthReader(
+	orionClient *client.NgsiV2Client,
+	baseURL string,
+	additionalHeaders map[string]string,
+	requestTimeout time.Duration,
+	logger *zap.Logger,
+) *HealthReader {
+	return &HealthReader{
+		orionClient:       orionClient,
+		baseURL:           strings.TrimSuffix(baseURL, "/"),
+		additionalHeaders: additionalHeaders,
+		httpClient:        &http.Client{Timeout: requestTimeout},
+		logger:            logger,
+	}
+}
+
+type subscriptionHealthResponse struct {
+	ID           string `json:"id"`
+	Notification struct {
+		FailsCounter      *uint  `json:"failsCounter"`
+		LastFailureReason string `json:"lastFailureReason"`
+	} `json:"notification"`
+}
+
+// ReadSubscriptionsHealthPage reads a page of the subscriptions of the fiware-service/service path,
+// with the total count reported by the context broker in the Fiware-Total-Count header
+func (r *HealthReader) ReadSubscriptionsHealthPage(
+	ctx context.Context,
+	fiwareService string,
+	servicePath string,
+	limit int,
+	offset int,
+) ([]*entities.SubscriptionHealth, int, error) {
+	subscriptionsURL, err := r.getSubscriptionsURL()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(limit))
+	query.Set("offset", strconv.Itoa(offset))
+	query.Set("options", "count")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, subscriptionsURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "could not create the read subscriptions health request")
+	}
+	req.Header.Set("Accept", "application/json")
+	for header, value := range r.additionalHeaders {
+		req.Header.Set(header, value)
+	}
+	if fiwareService != "" {
+		req.Header.Set("Fiware-Service", fiwareService)
+	}
+	if servicePath != "" {
+		req.Header.Set("Fiware-ServicePath", servicePath)
+	}
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, &entities.BrokerError{Operation: "read subscriptions health", Err: err}
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, &entities.BrokerError{Operation: "read subscriptions health", Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, &entities.BrokerError{
+			Operation:  "read subscriptions health",
+			StatusCode: resp.StatusCode,
+			Err:        errors.Errorf("response body: %s", string(body)),
+		}
+	}
+
+	var page []*subscriptionHealthResponse
+	if err := json.Unmarshal(body, &page); err != nil {
+		r.logger.Debug("could not unmarshal the subscriptions", zap.Error(err), zap.ByteString("body", body))
+		return nil, 0, &entities.BrokerError{
+			Operation:  "read subscriptions health",
+			StatusCode: resp.StatusCode,
+			Err:        errors.Wrap(err, "invalid response body"),
+		}
+	}
+
+	var count int
+	if header := resp.Header.Get("Fiware-Total-Count"); header != "" {
+		count, err = strconv.Atoi(header)
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "invalid Fiware-Total-Count header %q", header)
+		}
+	}
+
+	subscriptionsHealth := make([]*entities.SubscriptionHealth, len(page))
+	for i, sub := range page {
+		subscriptionsHealth[i] = &entities.SubscriptionHealth{
+			ID:                sub.ID,
+			FailsCounter:      sub.Notification.FailsCounter,
+			LastFailureReason: sub.Notification.LastFailureReason,
+		}
+	}
+	return subscriptionsHealth, count, nil
+}
+
+// getSubscriptionsURL returns the subscriptions api advertised by the context broker,
+// discovered once like the orion client does
+func (r *HealthReader) getSubscriptionsURL() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.subscriptionsURL != "" {
+		return r.subscriptionsURL, nil
+	}
+	resources, err := r.orionClient.RetrieveAPIResources()
+	if err != nil {
+		return "", err
+	}
+	r.subscriptionsURL = r.baseURL + resources.SubscriptionsUrl
+	return r.subscriptionsURL, nil
+}
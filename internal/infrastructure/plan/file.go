@@ -0,0 +1,52 @@
+package plan
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"go.uber.org/zap"
+)
+
+// FileStore saves and loads the subscriptions plans as json files
+type FileStore struct {
+	logger *zap.Logger
+}
+
+func NewFileStore(logger *zap.Logger) *FileStore {
+	return &FileStore{logger: logger}
+}
+
+func (s *FileStore) WritePlanFile(path string, plan *entities.SubscriptionsPlan) error {
+	content, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		s.logger.Debug("could not marshal the plan", zap.Error(err), zap.String("file_path", path))
+		return err
+	}
+
+	err = os.WriteFile(path, append(content, '\n'), 0600)
+	if err != nil {
+		s.logger.Debug("could not write the plan file", zap.Error(err), zap.String("file_path", path))
+		return err
+	}
+
+	return nil
+}
+
+func (s *FileStore) ReadPlanFile(path string) (*entities.SubscriptionsPlan, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		s.logger.Debug("could not read the file provided", zap.Error(err), zap.String("file_path", path))
+		return nil, err
+	}
+
+	var plan *entities.SubscriptionsPlan
+
+	err = json.Unmarshal(content, &plan)
+	if err != nil {
+		s.logger.Debug("could not unmarshal the file content", zap.Error(err), zap.String("file_path", path))
+		return nil, err
+	}
+
+	return plan, nil
+}
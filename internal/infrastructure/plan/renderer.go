@@ -0,0 +1,229 @@
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"github.com/phoops/ngsiv2/model"
+)
+
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorBold   = "\033[1m"
+)
+
+// Renderer renders the subscriptions patches computed by bellatrix
+// in a human readable or in a json format
+type Renderer struct {
+	colored bool
+}
+
+func NewRenderer(colored bool) *Renderer {
+	return &Renderer{colored: colored}
+}
+
+// Summary counts the operations contained in a set of patches
+type Summary struct {
+	ToAdd    int `json:"to_add"`
+	ToUpdate int `json:"to_update"`
+	ToDelete int `json:"to_delete"`
+	// Duplicates are included in ToDelete
+	Duplicates int `json:"duplicates"`
+	// Skipped counts the changes outside the selection, not included in the others
+	Skipped int `json:"skipped"`
+	// Inactive counts the subscriptions that went inactive on their own
+	Inactive int `json:"inactive"`
+}
+
+type jsonPlan struct {
+	Summary Summary                        `json:"summary"`
+	Patches []*entities.SubscriptionsPatch `json:"patches"`
+}
+
+// Summarize counts the operations contained in the patches
+func Summarize(patches []*entities.SubscriptionsPatch) Summary {
+	var summary Summary
+	for _, patch := range patches {
+		summary.ToAdd += len(patch.SubscriptionsToAdd)
+		summary.ToUpdate += len(patch.SubscriptionsToUpdate)
+		summary.ToDelete += len(patch.SubscriptionsToDelete) + len(patch.DuplicatesToDelete)
+		summary.Duplicates += len(patch.DuplicatesToDelete)
+		summary.Skipped += len(patch.Skipped)
+		summary.Inactive += len(patch.InactiveSubscriptions)
+	}
+	return summary
+}
+
+// RenderJSON writes the patches as a json document, suitable for CI pipelines
+func (r *Renderer) RenderJSON(w io.Writer, patches []*entities.SubscriptionsPatch) error {
+	if patches == nil {
+		patches = []*entities.SubscriptionsPatch{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(&jsonPlan{
+		Summary: Summarize(patches),
+		Patches: patches,
+	})
+}
+
+// RenderText writes the patches grouped by fiware-service and service path,
+// with a field level diff of every subscription involved
+func (r *Renderer) RenderText(w io.Writer, patches []*entities.SubscriptionsPatch) error {
+	p := &printer{w: w, colored: r.colored}
+
+	if len(patches) == 0 {
+		p.printf("No changes. Subscriptions state in sync.\n")
+		return p.err
+	}
+
+	sortedPatches := make([]*entities.SubscriptionsPatch, len(patches))
+	copy(sortedPatches, patches)
+	sort.SliceStable(sortedPatches, func(i, j int) bool {
+		if sortedPatches[i].FiwareService != sortedPatches[j].FiwareService {
+			return sortedPatches[i].FiwareService < sortedPatches[j].FiwareService
+		}
+		return sortedPatches[i].ServicePath < sortedPatches[j].ServicePath
+	})
+
+	currentService := ""
+	for i, patch := range sortedPatches {
+		if i == 0 || patch.FiwareService != currentService {
+			currentService = patch.FiwareService
+			p.colorf(colorBold, "Fiware-Service: %s\n", displayScope(patch.FiwareService))
+		}
+		p.colorf(colorBold, "  Service-Path: %s\n", displayScope(patch.ServicePath))
+
+		for _, sub := range patch.SubscriptionsToAdd {
+			p.colorf(colorGreen, "    + create %s\n", sub.Description)
+			p.printSubscription(colorGreen, "        + ", sub)
+		}
+		for _, update := range patch.SubscriptionsToUpdate {
+			p.colorf(colorYellow, "    ~ update %s (id %s)\n", update.Desired.Description, update.Current.Id)
+			for _, change := range update.Changes {
+				switch {
+				case change.Before == "":
+					p.colorf(colorGreen, "        + %s: %q\n", change.Field, change.After)
+				case change.After == "":
+					p.colorf(colorRed, "        - %s: %q\n", change.Field, change.Before)
+				default:
+					p.colorf(colorYellow, "        ~ %s: %q => %q\n", change.Field, change.Before, change.After)
+				}
+			}
+		}
+		for _, sub := range patch.SubscriptionsToDelete {
+			p.colorf(colorRed, "    - delete %s (id %s)\n", sub.Description, sub.Id)
+			p.printSubscription(colorRed, "        - ", sub)
+		}
+		for _, sub := range patch.DuplicatesToDelete {
+			p.colorf(colorRed, "    - delete duplicate %s (id %s)\n", sub.Description, sub.Id)
+		}
+		for _, sub := range patch.InactiveSubscriptions {
+			p.colorf(colorYellow, "    ! inactive %s (id %s), the state does not request it\n", sub.Description, sub.Id)
+		}
+		for _, skipped := range patch.Skipped {
+			if skipped.SubscriptionID == "" {
+				p.printf("    # skipped %s %s, outside the selection\n", skipped.Operation, skipped.Description)
+				continue
+			}
+			p.printf(
+				"    # skipped %s %s (id %s), outside the selection\n",
+				skipped.Operation,
+				skipped.Description,
+				skipped.SubscriptionID,
+			)
+		}
+		p.printf("\n")
+	}
+
+	summary := Summarize(patches)
+	p.printf(
+		"Plan: %d to create, %d to update, %d to delete",
+		summary.ToAdd,
+		summary.ToUpdate,
+		summary.ToDelete,
+	)
+	if summary.Duplicates > 0 {
+		p.printf(" (%d duplicates)", summary.Duplicates)
+	}
+	if summary.Skipped > 0 {
+		p.printf(", %d skipped", summary.Skipped)
+	}
+	if summary.Inactive > 0 {
+		p.printf(", %d inactive", summary.Inactive)
+	}
+	p.printf(".\n")
+
+	return p.err
+}
+
+func displayScope(scope string) string {
+	if scope == "" {
+		return "(default)"
+	}
+	return scope
+}
+
+// printer keeps the first write error, so the rendering code
+// does not need to check every single write
+type printer struct {
+	w       io.Writer
+	colored bool
+	err     error
+}
+
+func (p *printer) printf(format string, args ...interface{}) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = fmt.Fprintf(p.w, format, args...)
+}
+
+func (p *printer) colorf(color string, format string, args ...interface{}) {
+	if !p.colored {
+		p.printf(format, args...)
+		return
+	}
+	p.printf(color+format, args...)
+	p.printf(colorReset)
+}
+
+// printSubscription prints the subscription fields requested by the user,
+// without the fields populated by orion at runtime
+func (p *printer) printSubscription(color string, prefix string, sub *model.Subscription) {
+	content, err := json.MarshalIndent(requestedFields(sub), "", "  ")
+	if err != nil {
+		p.colorf(color, "%s%+v\n", prefix, sub)
+		return
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		p.colorf(color, "%s%s\n", prefix, line)
+	}
+}
+
+func requestedFields(sub *model.Subscription) *model.Subscription {
+	requested := &model.Subscription{
+		Subject:    sub.Subject,
+		Expires:    sub.Expires,
+		Status:     sub.Status,
+		Throttling: sub.Throttling,
+	}
+	if sub.Notification != nil {
+		requested.Notification = &model.SubscriptionNotification{
+			Attrs:       sub.Notification.Attrs,
+			ExceptAttrs: sub.Notification.ExceptAttrs,
+			Http:        sub.Notification.Http,
+			HttpCustom:  sub.Notification.HttpCustom,
+			AttrsFormat: sub.Notification.AttrsFormat,
+			Metadata:    sub.Notification.Metadata,
+		}
+	}
+	return requested
+}
@@ -0,0 +1,100 @@
+package plan
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+)
+
+// RenderReport writes a table with the outcome of every operation performed
+// on the context broker, followed by the count of succeeded and failed operations
+func (r *Renderer) RenderReport(w io.Writer, results []*entities.OperationResult) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	p := &printer{w: table}
+
+	p.printf("RESULT\tOPERATION\tFIWARE-SERVICE\tSERVICE-PATH\tDESCRIPTION\tID\tERROR\n")
+	succeeded, failed := 0, 0
+	for _, result := range results {
+		outcome, errorMessage := "ok", ""
+		if result.Err != nil {
+			outcome = "failed"
+			// the table is one line per operation
+			errorMessage = strings.Join(strings.Fields(result.Err.Error()), " ")
+			failed++
+		} else {
+			succeeded++
+		}
+		p.printf(
+			"%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			outcome,
+			result.Operation,
+			displayScope(result.FiwareService),
+			displayScope(result.ServicePath),
+			result.Description,
+			result.SubscriptionID,
+			errorMessage,
+		)
+	}
+	if p.err != nil {
+		return p.err
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "\n%d operations succeeded, %d failed.\n", succeeded, failed)
+	return err
+}
+
+// RenderHealReport writes a table with the failed subscriptions and the evidence
+// of their failure, with the outcome of their heal, or planned with dryRun
+func (r *Renderer) RenderHealReport(w io.Writer, results []*entities.OperationResult, dryRun bool) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	p := &printer{w: table}
+
+	p.printf("RESULT\tOPERATION\tFIWARE-SERVICE\tSERVICE-PATH\tDESCRIPTION\tID\tEVIDENCE\tERROR\n")
+	failed := 0
+	for _, result := range results {
+		outcome, errorMessage := "ok", ""
+		switch {
+		case dryRun:
+			outcome = "planned"
+		case result.Err != nil:
+			outcome = "failed"
+			// the table is one line per operation
+			errorMessage = strings.Join(strings.Fields(result.Err.Error()), " ")
+			failed++
+		}
+		p.printf(
+			"%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			outcome,
+			result.Operation,
+			displayScope(result.FiwareService),
+			displayScope(result.ServicePath),
+			result.Description,
+			result.SubscriptionID,
+			strings.Join(strings.Fields(result.Evidence), " "),
+			errorMessage,
+		)
+	}
+	if p.err != nil {
+		return p.err
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	var err error
+	switch {
+	case len(results) == 0:
+		_, err = fmt.Fprintf(w, "\nNo failed subscriptions.\n")
+	case dryRun:
+		_, err = fmt.Fprintf(w, "\n%d failed subscriptions to heal.\n", len(results))
+	default:
+		_, err = fmt.Fprintf(w, "\n%d subscriptions healed, %d failed.\n", len(results)-failed, failed)
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+)
+
+// StatusSummary counts the subscriptions of a status report
+type StatusSummary struct {
+	Subscriptions int `json:"subscriptions"`
+	Failed        int `json:"failed"`
+	Missing       int `json:"missing"`
+}
+
+type jsonStatus struct {
+	Summary       StatusSummary                        `json:"summary"`
+	Subscriptions []*entities.SubscriptionStatusReport `json:"subscriptions"`
+}
+
+// SummarizeStatus counts the failed and missing subscriptions of the reports
+func SummarizeStatus(reports []*entities.SubscriptionStatusReport) StatusSummary {
+	summary := StatusSummary{Subscriptions: len(reports)}
+	for _, report := range reports {
+		if report.Failed {
+			summary.Failed++
+		}
+		if report.Missing {
+			summary.Missing++
+		}
+	}
+	return summary
+}
+
+// RenderStatusJSON writes the status of the subscriptions as a json document
+func (r *Renderer) RenderStatusJSON(w io.Writer, reports []*entities.SubscriptionStatusReport) error {
+	if reports == nil {
+		reports = []*entities.SubscriptionStatusReport{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(&jsonStatus{
+		Summary:       SummarizeStatus(reports),
+		Subscriptions: reports,
+	})
+}
+
+// RenderStatus writes a table with the health of every subscription,
+// followed by the count of failed and missing subscriptions
+func (r *Renderer) RenderStatus(w io.Writer, reports []*entities.SubscriptionStatusReport) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	p := &printer{w: table}
+
+	p.printf(
+		"HEALTH\tFIWARE-SERVICE\tSERVICE-PATH\tDESCRIPTION\tID\tSTATUS\tTIMES-SENT\t" +
+			"LAST-NOTIFICATION\tLAST-SUCCESS\tLAST-FAILURE\tLAST-SUCCESS-CODE\tLAST-FAILURE-REASON\n",
+	)
+	for _, report := range reports {
+		health := "ok"
+		switch {
+		case report.Missing:
+			health = "missing"
+		case report.Failed:
+			health = "failed"
+		}
+		timesSent, lastSuccessCode := "", ""
+		if !report.Missing {
+			timesSent = fmt.Sprintf("%d", report.TimesSent)
+		}
+		if report.LastSuccessCode != nil {
+			lastSuccessCode = fmt.Sprintf("%d", *report.LastSuccessCode)
+		}
+		p.printf(
+			"%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			health,
+			displayScope(report.FiwareService),
+			displayScope(report.ServicePath),
+			report.Description,
+			report.SubscriptionID,
+			report.Status,
+			timesSent,
+			displayTime(report.LastNotification),
+			displayTime(report.LastSuccess),
+			displayTime(report.LastFailure),
+			lastSuccessCode,
+			// the table is one line per subscription
+			strings.Join(strings.Fields(report.LastFailureReason), " "),
+		)
+	}
+	if p.err != nil {
+		return p.err
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	summary := SummarizeStatus(reports)
+	_, err := fmt.Fprintf(
+		w,
+		"\n%d subscriptions, %d failed, %d missing.\n",
+		summary.Subscriptions,
+		summary.Failed,
+		summary.Missing,
+	)
+	return err
+}
+
+func displayTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
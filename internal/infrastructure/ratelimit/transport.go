@@ -0,0 +1,147 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// Transport is a http.RoundTripper limiting the requests sent to the context broker
+// with a token bucket, shared by every request made through it.
+// When the context broker answers 429 with a Retry-After header, every following request
+// is paused for the requested delay. The rejected request is not sent again here:
+// the 429 is returned to the caller, whose retry policy owns the retries.
+// The waits end when the context of the request or the context of the run is done,
+// the orion client never sets the context of its requests.
+type Transport struct {
+	ctx     context.Context
+	next    http.RoundTripper
+	limiter *rate.Limiter
+	logger  *zap.Logger
+
+	mu          sync.Mutex
+	pausedUntil time.Time
+}
+
+// NewTransport returns a transport allowing requestsPerSecond requests with the given burst,
+// a requestsPerSecond of 0 disables the token bucket, Retry-After is honored anyway,
+// ctx is the context of the run
+func NewTransport(
+	ctx context.Context,
+	next http.RoundTripper,
+	requestsPerSecond float64,
+	burst int,
+	logger *zap.Logger,
+) *Transport {
+	limit := rate.Inf
+	if requestsPerSecond > 0 {
+		limit = rate.Limit(requestsPerSecond)
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Transport{
+		ctx:     ctx,
+		next:    next,
+		limiter: rate.NewLimiter(limit, burst),
+		logger:  logger,
+	}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.wait(req); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+		return resp, err
+	}
+
+	if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+		t.logger.Warn(
+			"Context broker is rate limiting the requests, pausing them",
+			zap.String("method", req.Method),
+			zap.String("url", req.URL.String()),
+			zap.Duration("retry_after", delay),
+		)
+		t.pause(delay)
+	}
+	return resp, nil
+}
+
+// wait blocks until the context broker accepts requests again
+// and a token is available in the bucket
+func (t *Transport) wait(req *http.Request) error {
+	t.mu.Lock()
+	pausedUntil := t.pausedUntil
+	t.mu.Unlock()
+
+	if err := t.sleep(req, time.Until(pausedUntil)); err != nil {
+		return err
+	}
+
+	// the burst is at least 1, a single token can always be reserved
+	reservation := t.limiter.Reserve()
+	if err := t.sleep(req, reservation.Delay()); err != nil {
+		reservation.Cancel()
+		return err
+	}
+	return nil
+}
+
+// sleep waits for the delay, it stops early when the context of the request
+// or the context of the run is done
+func (t *Transport) sleep(req *http.Request, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	case <-t.ctx.Done():
+		return t.ctx.Err()
+	}
+}
+
+func (t *Transport) pause(delay time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	until := time.Now().Add(delay)
+	if until.After(t.pausedUntil) {
+		t.pausedUntil = until
+	}
+}
+
+// parseRetryAfter reads the Retry-After header, expressed either
+// in seconds or as an http date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		delay := date.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
@@ -0,0 +1,47 @@
+package state
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/phoops/bellatrix/internal/core/entities"
+	"go.uber.org/zap"
+)
+
+type Writer struct {
+	logger *zap.Logger
+}
+
+func NewWriter(logger *zap.Logger) *Writer {
+	return &Writer{logger: logger}
+}
+
+// WriteSubscriptionFile writes the state as an indented json document,
+// the file is replaced atomically so a failure never leaves a truncated state
+func (w *Writer) WriteSubscriptionFile(path string, subsState *entities.SubscriptionsRequestedState) error {
+	content, err := json.MarshalIndent(subsState, "", "  ")
+	if err != nil {
+		w.logger.Debug("could not marshal the state", zap.Error(err), zap.String("file_path", path))
+		return err
+	}
+
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmpPath := path + ".tmp"
+	err = os.WriteFile(tmpPath, append(content, '\n'), mode)
+	if err != nil {
+		w.logger.Debug("could not write the state file", zap.Error(err), zap.String("file_path", tmpPath))
+		return err
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		w.logger.Debug("could not replace the state file", zap.Error(err), zap.String("file_path", path))
+		return err
+	}
+
+	return nil
+}